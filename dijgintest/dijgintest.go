@@ -0,0 +1,349 @@
+// Copyright 2022 Yuchi Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+// Package dijgintest provides utilities for testing dij-gin controllers in process, without binding network ports.
+//
+//	func TestGetUser(t *testing.T) {
+//	  s := dijgintest.New(t, &TWebServer{}, dijgintest.WithFake("accountDb", &FakeAccountDb{}))
+//	  s.GET("/user/123").Query("detail", "true").Do().
+//	    ExpectStatus(http.StatusOK).
+//	    ExpectJSONPath("name", "john")
+//	}
+package dijgintest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	. "github.com/letscool/dij-gin"
+	"github.com/letscool/lc-go/dij"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type options struct {
+	config *WebConfig
+	fakes  map[string]any
+}
+
+type Option func(o *options)
+
+// WithConfig uses config to prepare the web server. It is copied, so the original config is not changed by fakes.
+func WithConfig(config *WebConfig) Option {
+	return func(o *options) {
+		o.config = config
+	}
+}
+
+// WithFake overrides the dependency with key, ex: the key of `di:"accountDb"` field is "accountDb".
+func WithFake(key string, fake any) Option {
+	return func(o *options) {
+		o.fakes[key] = fake
+	}
+}
+
+// WithFakeOf overrides the dependency which is injected by type, aka. `di:"^"` field with same type as fake.
+func WithFakeOf(fake any) Option {
+	return WithFake(dij.FullnameOfType(reflect.TypeOf(fake)), fake)
+}
+
+// Server wraps a web server prepared by PrepareGin.
+type Server struct {
+	t      testing.TB
+	engine *gin.Engine
+	ref    dij.DependencyReferencePtr
+	http   *httptest.Server
+}
+
+// New prepares a web server for testing, the test fails immediately if PrepareGin returns an error.
+// Runtime environment is "test" and logs are discarded by default.
+func New(t testing.TB, webServerTypeOrInst any, opts ...Option) *Server {
+	t.Helper()
+	o := options{fakes: map[string]any{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	var config WebConfig
+	if o.config != nil {
+		config = *o.config
+	} else {
+		config = *NewWebConfig().SetRtMode(RtTest).SetDefaultWriter(io.Discard)
+	}
+	refs := make(map[string]any, len(config.DependentRefs)+len(o.fakes))
+	for k, v := range config.DependentRefs {
+		refs[k] = v
+	}
+	for k, v := range o.fakes {
+		refs[k] = v
+	}
+	config.DependentRefs = refs
+
+	engine, ref, err := PrepareGin(webServerTypeOrInst, &config)
+	if err != nil {
+		t.Fatalf("prepare web server error: %v", err)
+	}
+	return &Server{t: t, engine: engine, ref: ref}
+}
+
+// Engine returns the gin engine.
+func (s *Server) Engine() *gin.Engine {
+	return s.engine
+}
+
+// Ref returns the dependency reference, it can be used to retrieve instances created by dij.
+func (s *Server) Ref() dij.DependencyReferencePtr {
+	return s.ref
+}
+
+// HttpServer starts a real http server on loopback interface for clients needing a url,
+// it is closed when the test finishes.
+func (s *Server) HttpServer() *httptest.Server {
+	if s.http == nil {
+		s.http = httptest.NewServer(s.engine)
+		s.t.Cleanup(s.http.Close)
+	}
+	return s.http
+}
+
+func (s *Server) NewRequest(method string, path string) *Request {
+	return &Request{
+		s:      s,
+		method: method,
+		path:   path,
+		query:  url.Values{},
+		header: http.Header{},
+	}
+}
+
+func (s *Server) GET(path string) *Request {
+	return s.NewRequest(http.MethodGet, path)
+}
+
+func (s *Server) POST(path string) *Request {
+	return s.NewRequest(http.MethodPost, path)
+}
+
+func (s *Server) PUT(path string) *Request {
+	return s.NewRequest(http.MethodPut, path)
+}
+
+func (s *Server) PATCH(path string) *Request {
+	return s.NewRequest(http.MethodPatch, path)
+}
+
+func (s *Server) DELETE(path string) *Request {
+	return s.NewRequest(http.MethodDelete, path)
+}
+
+// Request is a fluent request builder.
+type Request struct {
+	s       *Server
+	method  string
+	path    string
+	query   url.Values
+	header  http.Header
+	cookies []*http.Cookie
+	body    []byte
+}
+
+func (r *Request) Query(key string, values ...string) *Request {
+	for _, v := range values {
+		r.query.Add(key, v)
+	}
+	return r
+}
+
+func (r *Request) Header(key string, value string) *Request {
+	r.header.Add(key, value)
+	return r
+}
+
+func (r *Request) Cookie(name string, value string) *Request {
+	r.cookies = append(r.cookies, &http.Cookie{Name: name, Value: value})
+	return r
+}
+
+func (r *Request) BearerToken(token string) *Request {
+	return r.Header("Authorization", "Bearer "+token)
+}
+
+func (r *Request) BasicAuth(user string, password string) *Request {
+	req := http.Request{Header: http.Header{}}
+	req.SetBasicAuth(user, password)
+	return r.Header("Authorization", req.Header.Get("Authorization"))
+}
+
+// Body sets raw body with content type.
+func (r *Request) Body(contentType string, body []byte) *Request {
+	r.header.Set("Content-Type", contentType)
+	r.body = body
+	return r
+}
+
+// JSON sets body as json encoded v.
+func (r *Request) JSON(v any) *Request {
+	data, err := json.Marshal(v)
+	if err != nil {
+		r.s.t.Fatalf("marshal json body error: %v", err)
+	}
+	return r.Body("application/json", data)
+}
+
+// Form sets body as url encoded values.
+func (r *Request) Form(values url.Values) *Request {
+	return r.Body("application/x-www-form-urlencoded", []byte(values.Encode()))
+}
+
+// Do serves the request in process.
+func (r *Request) Do() *Response {
+	target := r.path
+	if len(r.query) > 0 {
+		sep := "?"
+		if strings.Contains(target, "?") {
+			sep = "&"
+		}
+		target += sep + r.query.Encode()
+	}
+	var body io.Reader
+	if r.body != nil {
+		body = bytes.NewReader(r.body)
+	}
+	req := httptest.NewRequest(r.method, target, body)
+	for k, v := range r.header {
+		req.Header[k] = v
+	}
+	for _, c := range r.cookies {
+		req.AddCookie(c)
+	}
+	recorder := httptest.NewRecorder()
+	r.s.engine.ServeHTTP(recorder, req)
+	return &Response{t: r.s.t, Recorder: recorder}
+}
+
+// Response provides assertions, a failed assertion marks the test failed and continues.
+type Response struct {
+	t        testing.TB
+	Recorder *httptest.ResponseRecorder
+}
+
+func (r *Response) Code() int {
+	return r.Recorder.Code
+}
+
+func (r *Response) Body() string {
+	return r.Recorder.Body.String()
+}
+
+// DecodeJSON decodes body into v.
+func (r *Response) DecodeJSON(v any) *Response {
+	r.t.Helper()
+	if err := json.Unmarshal(r.Recorder.Body.Bytes(), v); err != nil {
+		r.t.Errorf("decode json body error: %v, body: %s", err, r.Body())
+	}
+	return r
+}
+
+func (r *Response) ExpectStatus(code int) *Response {
+	r.t.Helper()
+	if r.Recorder.Code != code {
+		r.t.Errorf("expect status %d, but got %d, body: %s", code, r.Recorder.Code, r.Body())
+	}
+	return r
+}
+
+func (r *Response) ExpectHeader(key string, value string) *Response {
+	r.t.Helper()
+	if v := r.Recorder.Header().Get(key); v != value {
+		r.t.Errorf("expect header %s: %q, but got %q", key, value, v)
+	}
+	return r
+}
+
+func (r *Response) ExpectBody(body string) *Response {
+	r.t.Helper()
+	if r.Body() != body {
+		r.t.Errorf("expect body %q, but got %q", body, r.Body())
+	}
+	return r
+}
+
+// ExpectJSONPath checks the value in json body, path is separated by dot and array index is a number,
+// ex: "data.items.0.name". The empty path means the whole body.
+func (r *Response) ExpectJSONPath(path string, expected any) *Response {
+	r.t.Helper()
+	actual, err := r.JSONPath(path)
+	if err != nil {
+		r.t.Errorf("%v, body: %s", err, r.Body())
+		return r
+	}
+	normalized, err := normalizeJSON(expected)
+	if err != nil {
+		r.t.Errorf("marshal expected value error: %v", err)
+		return r
+	}
+	if !reflect.DeepEqual(actual, normalized) {
+		r.t.Errorf("expect json path '%s' is %v, but got %v", path, normalized, actual)
+	}
+	return r
+}
+
+// ExpectWebError checks the body is a WebError with code.
+func (r *Response) ExpectWebError(code string) *Response {
+	r.t.Helper()
+	var webErr WebError
+	if err := json.Unmarshal(r.Recorder.Body.Bytes(), &webErr); err != nil {
+		r.t.Errorf("body is not a web error: %v, body: %s", err, r.Body())
+	} else if webErr.Code != code {
+		r.t.Errorf("expect web error code %s, but got %s(%s)", code, webErr.Code, webErr.Message)
+	}
+	return r
+}
+
+// JSONPath retrieves the value in json body, see ExpectJSONPath for format of path.
+func (r *Response) JSONPath(path string) (any, error) {
+	var data any
+	if err := json.Unmarshal(r.Recorder.Body.Bytes(), &data); err != nil {
+		return nil, fmt.Errorf("body is not json: %w", err)
+	}
+	if len(path) == 0 {
+		return data, nil
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch v := data.(type) {
+		case map[string]any:
+			value, ok := v[key]
+			if !ok {
+				return nil, fmt.Errorf("json path '%s' doesn't exist, missing key '%s'", path, key)
+			}
+			data = value
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, fmt.Errorf("json path '%s' doesn't exist, incorrect index '%s'", path, key)
+			}
+			data = v[i]
+		default:
+			return nil, fmt.Errorf("json path '%s' doesn't exist, '%s' is not in object or array", path, key)
+		}
+	}
+	return data, nil
+}
+
+// normalizeJSON converts v to the form of json.Unmarshal into any, so numbers become float64, etc.
+func normalizeJSON(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out any
+	err = json.Unmarshal(data, &out)
+	return out, err
+}
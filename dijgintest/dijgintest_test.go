@@ -0,0 +1,85 @@
+// Copyright 2022 Yuchi Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package dijgintest
+
+import (
+	"errors"
+	. "github.com/letscool/dij-gin"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+)
+
+type TUserStore struct {
+	users map[int]string
+}
+
+type TUserServer struct {
+	WebServer
+
+	store *TUserStore `di:"userStore"`
+}
+
+func (s *TUserServer) GetUser(ctx struct {
+	WebContext `http:"user/:id"`
+	Id         int `http:"id,in=path"`
+}) {
+	if name, ok := s.store.users[ctx.Id]; ok {
+		ctx.Header("X-User-Id", ctx.Param("id"))
+		ctx.JSON(http.StatusOK, map[string]any{"id": ctx.Id, "name": name, "tags": []string{"a", "b"}})
+	} else {
+		ctx.JSON(http.StatusNotFound, ToWebError(errors.New("user not found"), "user_not_found"))
+	}
+}
+
+func (s *TUserServer) PostUser(ctx struct {
+	WebContext `http:"user"`
+	Name       string `http:"name,in=body"`
+}) {
+	ctx.JSON(http.StatusOK, map[string]any{"name": ctx.Name})
+}
+
+func TestServer(t *testing.T) {
+	s := New(t, &TUserServer{}, WithFake("userStore", &TUserStore{users: map[int]string{1: "john"}}))
+
+	s.GET("/user/1").Do().
+		ExpectStatus(http.StatusOK).
+		ExpectHeader("X-User-Id", "1").
+		ExpectJSONPath("id", 1).
+		ExpectJSONPath("name", "john").
+		ExpectJSONPath("tags.1", "b")
+
+	s.GET("/user/2").Do().
+		ExpectStatus(http.StatusNotFound).
+		ExpectWebError("user_not_found")
+
+	s.POST("/user").Form(url.Values{"name": {"mary"}}).Do().
+		ExpectStatus(http.StatusOK).
+		ExpectJSONPath("", map[string]any{"name": "mary"})
+
+	resp := s.GET("/user/1").Do()
+	if _, err := resp.JSONPath("tags.2"); err == nil {
+		t.Error("index out of range should be an error")
+	}
+}
+
+func TestServerIsQuiet(t *testing.T) {
+	stdout, stderr := os.Stdout, os.Stderr
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout, os.Stderr = w, w
+	s := New(t, &TUserServer{}, WithFake("userStore", &TUserStore{users: map[int]string{1: "john"}}))
+	s.GET("/user/1").Do()
+	s.GET("/user/x").Do() // a binding failure is logged as warning
+	os.Stdout, os.Stderr = stdout, stderr
+	_ = w.Close()
+	if out, _ := io.ReadAll(r); len(out) > 0 {
+		t.Errorf("logs should be discarded, but got: %s", out)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2022 Yuchi Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package libs
+
+import (
+	"errors"
+	. "github.com/letscool/dij-gin"
+	"github.com/letscool/lc-go/dij"
+	"net/http"
+	"strconv"
+)
+
+// FeatureFlagController serves an admin API to list and flip feature flags, the provider should be a MutableFeatureFlagProvider.
+// It should be protected by the middleware of its parent controller, ex:
+//
+//	type TAdminController struct {
+//	  WebController `http:"admin,middleware=auth,inherit"`
+//
+//	  _ *libs.FeatureFlagController `di:""` // GET /admin/flags, PUT /admin/flags/:name
+//	}
+type FeatureFlagController struct {
+	WebController `http:"flags,tag=Feature Flags"`
+
+	ref *dij.DependencyReference `di:"_.webserver.dij.ref"`
+}
+
+func (c *FeatureFlagController) provider() (MutableFeatureFlagProvider, error) {
+	provider, ok := GetFeatureFlagProvider(c.ref)
+	if !ok {
+		return nil, errors.New("feature flag provider doesn't exist")
+	}
+	mutable, ok := provider.(MutableFeatureFlagProvider)
+	if !ok {
+		return nil, errors.New("feature flag provider is not mutable")
+	}
+	return mutable, nil
+}
+
+// Get lists all feature flags.
+func (c *FeatureFlagController) Get(ctx struct {
+	WebContext `http:"" description:"List all feature flags"`
+}) {
+	provider, err := c.provider()
+	if err != nil {
+		ctx.JSON(http.StatusNotImplemented, ToWebError(err, strconv.Itoa(http.StatusNotImplemented)))
+		return
+	}
+	ctx.JSON(http.StatusOK, provider.Flags())
+}
+
+// Put turns a feature flag on or off.
+// curl: curl -X PUT http://localhost:8000/flags/beta -H 'Content-Type: application/json' -d '{"enabled":true}'
+func (c *FeatureFlagController) Put(ctx struct {
+	WebContext `http:":name" description:"Turn a feature flag on or off"`
+	Name       string `http:"name,in=path"`
+	Flag       struct {
+		Enabled *bool `json:"enabled" form:"enabled" binding:"required"`
+	}
+}) {
+	provider, err := c.provider()
+	if err != nil {
+		ctx.JSON(http.StatusNotImplemented, ToWebError(err, strconv.Itoa(http.StatusNotImplemented)))
+		return
+	}
+	if ctx.Flag.Enabled == nil {
+		ctx.JSON(http.StatusBadRequest, ToWebError(errors.New("enabled is required"), strconv.Itoa(http.StatusBadRequest)))
+		return
+	}
+	provider.SetFlag(ctx.Name, *ctx.Flag.Enabled)
+	ctx.JSON(http.StatusOK, provider.Flags())
+}
@@ -25,14 +25,14 @@ var content embed.FS
 type SwaggerController struct {
 	WebController `http:""`
 
-	ref         *dij.DependencyReference `di:"_.webserver.dij.ref"`
-	openapiSpec *spec.Openapi
+	ref *dij.DependencyReference `di:"_.webserver.dij.ref"`
 }
 
 func (s *SwaggerController) Open(name string) (fs.File, error) {
 	if name == "swagger.json" || name == "./swagger.json" {
 		// TODO: switch marshal compact or pretty format by debug or production mode
-		data, err := json.MarshalIndent(s.openapiSpec, "", "  ")
+		// the document is swapped if controllers are attached or detached at runtime.
+		data, err := json.MarshalIndent(GetDynamicRoutes(s.ref).Openapi(), "", "  ")
 		if err != nil {
 			return nil, err
 		}
@@ -48,11 +48,8 @@ func (s *SwaggerController) Open(name string) (fs.File, error) {
 }
 
 func (s *SwaggerController) SetupRouter(router WebRouter, _ ...any) {
-	if rec, ok := (*(s.ref))[RefKeyForWebSpecRecord]; ok {
+	if rec, ok := (*(s.ref))[RefKeyForWebSpecRecord]; ok && rec.(*spec.Openapi) != nil {
 		config := (*(s.ref))[RefKeyForWebConfig].(*WebConfig)
-		s.openapiSpec = rec.(*spec.Openapi)
-		if s.openapiSpec != nil {
-			router.StaticFS(config.OpenApi.DocPath, http.FS(s))
-		}
+		router.StaticFS(config.OpenApi.DocPath, http.FS(s))
 	}
 }
@@ -0,0 +1,32 @@
+// Copyright 2022 Yuchi Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package libs
+
+import (
+	. "github.com/letscool/dij-gin"
+	"github.com/letscool/lc-go/dij"
+	"net/http"
+)
+
+// RouteTableController serves the route table as json in dev environment, ex: http://localhost:8000/_debug/routes.
+type RouteTableController struct {
+	WebController `http:"_debug,env=dev"`
+
+	ref *dij.DependencyReference `di:"_.webserver.dij.ref"`
+}
+
+// GetRoutes lists all registered routes.
+func (c *RouteTableController) GetRoutes(ctx struct {
+	WebContext `http:"routes" description:"List all registered routes"`
+}) {
+	table := RouteTable{}
+	if static := GetRouteTable(c.ref); static != nil {
+		table.Routes = append(table.Routes, static.Routes...)
+	}
+	if dynamic := GetDynamicRoutes(c.ref); dynamic != nil {
+		table.Routes = append(table.Routes, dynamic.Routes()...)
+	}
+	ctx.JSON(http.StatusOK, table)
+}
@@ -0,0 +1,245 @@
+// Copyright 2022 Yuchi Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package libs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	. "github.com/letscool/dij-gin"
+	"github.com/letscool/lc-go/lg"
+	"io/fs"
+	"net/http"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultSpaConfigVar   = "__DIJGIN_CONFIG__"
+	ImmutableCacheControl = "public, max-age=31536000, immutable"
+)
+
+// SpaConfig presents the settings of SpaController.
+type SpaConfig struct {
+	FS            fs.FS                  // build output of single-page application, ex: embed.FS
+	Root          string                 // sub directory in FS, ex: "dist"
+	Index         string                 // default is index.html
+	Exclude       []string               // path prefixes which never fall back to index file, ex: "/api"
+	RuntimeConfig map[string]any         // injected into index file with "basePath", which is WebConfig.BasePath.
+	ConfigVar     string                 // global variable of runtime config in browser, default is __DIJGIN_CONFIG__
+	IsHashed      func(name string) bool // hashed assets are cached as immutable, default is IsHashedAsset.
+}
+
+// SpaController hosts a single-page application, it should be embedded in your controller with the http tag:
+//
+//	type TFrontend struct {
+//	  libs.SpaController `http:"app,ref=frontend,exclude=/app/api"`
+//	}
+//
+// The attributes of http tag:
+//   - ref: key of DependentRefs, the value is SpaConfig, *SpaConfig or fs.FS. Other attributes override it.
+//   - root: sub directory in FS.
+//   - index: index file, default is index.html.
+//   - exclude: path prefixes separated by '&', which never fall back to index file.
+//
+// Unmatched GET/HEAD requests under the controller path are served from FS, and a request for a page
+// (no file extension and accepting text/html) falls back to the index file. Other requests are passed to
+// the NoRoute handler of web server, and API routes are always matched before it.
+// The runtime config is injected into the head of index file:
+//
+//	<script>window.__DIJGIN_CONFIG__={"basePath":"/api"}</script>
+type SpaController struct {
+	WebController
+
+	Config   SpaConfig
+	files    StaticController
+	prefix   string
+	index    []byte
+	indexTag string
+}
+
+func (s *SpaController) SetupRouter(router WebRouter, others ...any) {
+	instPtr, attrs, errs := parseSetupRouterArgs(others)
+	controller := reflect.TypeOf(instPtr)
+	for controller != nil && controller.Kind() == reflect.Pointer {
+		controller = controller.Elem()
+	}
+	if err := s.setup(router, attrs); err != nil {
+		errs.Add(&SetupError{Controller: controller, Reason: err.Error()})
+		return
+	}
+	v, _ := s.GetRef(RefKeyForWebNoRoute)
+	if chain, ok := v.(*NoRouteChain); ok {
+		chain.AddFallback(s.fallback)
+	}
+	if v, ok := s.GetRef(RefKeyForWebRouteTable); ok {
+		v.(*RouteTable).Add(RouteInfo{
+			Method:     "NOROUTE",
+			Path:       JoinRoutePath(s.prefix, "/*filepath"),
+			Controller: controller.String(),
+			Handler:    "SpaController",
+		})
+	}
+}
+
+func (s *SpaController) setup(router WebRouter, attrs lg.StructTagAttrs) error {
+	if attr, ok := attrs.FirstAttrsWithKey("ref"); ok {
+		v, exists := s.GetRef(attr.Val)
+		if !exists {
+			return fmt.Errorf("spa config(%s) doesn't exist in DependentRefs", attr.Val)
+		}
+		switch c := v.(type) {
+		case SpaConfig:
+			s.Config = c
+		case *SpaConfig:
+			s.Config = *c
+		case fs.FS:
+			s.Config.FS = c
+		default:
+			return fmt.Errorf("spa config(%s) should be SpaConfig or fs.FS, but got %T", attr.Val, v)
+		}
+	}
+	if attr, ok := attrs.FirstAttrsWithKey("root"); ok {
+		s.Config.Root = attr.Val
+	}
+	if attr, ok := attrs.FirstAttrsWithKey("index"); ok {
+		s.Config.Index = attr.Val
+	}
+	if attr, ok := attrs.FirstAttrsWithKey("exclude"); ok {
+		s.Config.Exclude = strings.Split(attr.Val, "&")
+	}
+	if s.Config.FS == nil {
+		return errors.New("spa controller needs a FS")
+	}
+	if len(s.Config.Index) == 0 {
+		s.Config.Index = "index.html"
+	}
+	if len(s.Config.ConfigVar) == 0 {
+		s.Config.ConfigVar = DefaultSpaConfigVar
+	}
+	if s.Config.IsHashed == nil {
+		s.Config.IsHashed = IsHashedAsset
+	}
+
+	s.files.Config = StaticConfig{
+		FS:         s.Config.FS,
+		Root:       s.Config.Root,
+		IndexFiles: []string{s.Config.Index},
+		CacheControl: func(name string) string {
+			return lg.Ife(s.Config.IsHashed(name), ImmutableCacheControl, "")
+		},
+	}
+	fsys, err := s.files.Config.open()
+	if err != nil {
+		return err
+	}
+	s.files.fsys = fsys
+	if routes, ok := router.(WebRoutes); ok {
+		s.prefix = routes.BasePath()
+	}
+	return s.renderIndex()
+}
+
+// renderIndex injects runtime config into the index file.
+func (s *SpaController) renderIndex() error {
+	data, err := fs.ReadFile(s.files.fsys, s.Config.Index)
+	if err != nil {
+		return fmt.Errorf("spa index file error: %w", err)
+	}
+	runtimeConfig := map[string]any{}
+	if v, ok := s.GetRef(RefKeyForWebConfig); ok {
+		runtimeConfig["basePath"] = path.Join("/", v.(*WebConfig).BasePath)
+	}
+	for k, v := range s.Config.RuntimeConfig {
+		runtimeConfig[k] = v
+	}
+	configJson, err := json.Marshal(runtimeConfig) // <, > and & are escaped, so it's safe in script.
+	if err != nil {
+		return fmt.Errorf("spa runtime config error: %w", err)
+	}
+	script := []byte(fmt.Sprintf("<script>window.%s=%s</script>", s.Config.ConfigVar, configJson))
+	if i := bytes.Index(bytes.ToLower(data), []byte("</head>")); i >= 0 {
+		s.index = append(append(append([]byte{}, data[:i]...), script...), data[i:]...)
+	} else {
+		s.index = append(script, data...)
+	}
+	hash := sha256.Sum256(s.index)
+	s.indexTag = `"` + hex.EncodeToString(hash[:16]) + `"`
+	return nil
+}
+
+// fallback serves files and pages for unmatched requests under the controller path.
+func (s *SpaController) fallback(c *gin.Context) {
+	if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		return
+	}
+	urlPath := c.Request.URL.Path
+	if urlPath != s.prefix && !strings.HasPrefix(urlPath, strings.TrimSuffix(s.prefix, "/")+"/") {
+		return
+	}
+	for _, exclude := range s.Config.Exclude {
+		if exclude = strings.TrimSpace(exclude); len(exclude) > 0 && strings.HasPrefix(urlPath, exclude) {
+			return
+		}
+	}
+	name, ok := cleanStaticPath(strings.TrimPrefix(urlPath, s.prefix))
+	if !ok {
+		return
+	}
+	if name != "." && name != s.Config.Index {
+		if info, err := fs.Stat(s.files.fsys, name); err == nil && !info.IsDir() {
+			c.Status(http.StatusOK)
+			s.files.serveFile(c, name)
+			c.Abort()
+			return
+		}
+		if len(path.Ext(name)) > 0 || !strings.Contains(c.GetHeader("Accept"), "text/html") {
+			// a missing asset or an api request
+			return
+		}
+	}
+	header := c.Writer.Header()
+	header.Set("ETag", s.indexTag)
+	header.Set("Cache-Control", "no-cache")
+	c.Status(http.StatusOK)
+	http.ServeContent(c.Writer, c.Request, s.Config.Index, time.Time{}, bytes.NewReader(s.index))
+	c.Abort()
+}
+
+// IsHashedAsset returns true if the file name contains a content hash, ex: app.3f2a1b9c.js or index-B2x9kQ7a.css.
+// The hash should be at least 8 characters of letters and digits, and contain a digit.
+func IsHashedAsset(name string) bool {
+	base := path.Base(name)
+	ext := path.Ext(base)
+	if len(ext) == 0 {
+		return false
+	}
+	base = strings.TrimSuffix(base, ext)
+	i := strings.LastIndexAny(base, ".-")
+	if i < 0 {
+		return false
+	}
+	hash := base[i+1:]
+	if len(hash) < 8 {
+		return false
+	}
+	hasDigit := false
+	for _, r := range hash {
+		switch {
+		case r >= '0' && r <= '9':
+			hasDigit = true
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_':
+		default:
+			return false
+		}
+	}
+	return hasDigit
+}
@@ -0,0 +1,341 @@
+// Copyright 2022 Yuchi Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package libs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	. "github.com/letscool/dij-gin"
+	"github.com/letscool/lc-go/dij"
+	"github.com/letscool/lc-go/lg"
+	"html"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StaticConfig presents the settings of StaticController.
+type StaticConfig struct {
+	Dir          string                   // directory on disk, FS is used if it's empty.
+	FS           fs.FS                    // ex: embed.FS
+	Root         string                   // sub directory in Dir or FS, ex: "dist"
+	IndexFiles   []string                 // files served for a directory, default is index.html
+	Listing      bool                     // list files of a directory without index file, default is false.
+	MaxAge       time.Duration            // max-age of Cache-Control, zero means "no-cache", aka. always revalidate by ETag/Last-Modified.
+	CacheControl func(name string) string // overrides MaxAge if it returns non-empty value, name is the path in FS.
+}
+
+// StaticController serves files from a directory or an embed.FS, it should be embedded in your controller with the http tag:
+//
+//	type TAssets struct {
+//	  libs.StaticController `http:"assets,dir=./public,max-age=1h"`
+//	}
+//
+// The attributes of http tag:
+//   - ref: key of DependentRefs, the value is StaticConfig, *StaticConfig or fs.FS. Other attributes override it.
+//   - dir: directory on disk.
+//   - root: sub directory in dir or FS.
+//   - index: index files separated by '&', ex: index=index.html&index.htm
+//   - listing: enable directory listing.
+//   - max-age: max-age of Cache-Control, ex: max-age=3600 or max-age=1h
+//
+// Files are served with ETag and Last-Modified, the ETag of files without modification time (ex: embed.FS) is the hash of content.
+// Hidden files (name starts with '.') are never served.
+type StaticController struct {
+	WebController
+
+	Config StaticConfig
+	fsys   fs.FS
+	etags  sync.Map // name -> etag, only for files without modification time
+}
+
+func (s *StaticController) SetupRouter(router WebRouter, others ...any) {
+	instPtr, attrs, errs := parseSetupRouterArgs(others)
+	controller := reflect.TypeOf(instPtr)
+	for controller != nil && controller.Kind() == reflect.Pointer {
+		controller = controller.Elem()
+	}
+	if err := s.applyAttrs(attrs); err != nil {
+		errs.Add(&SetupError{Controller: controller, Reason: err.Error()})
+		return
+	}
+	fsys, err := s.Config.open()
+	if err != nil {
+		errs.Add(&SetupError{Controller: controller, Reason: err.Error()})
+		return
+	}
+	s.fsys = fsys
+	router.GET("/*filepath", s.serve)
+	router.HEAD("/*filepath", s.serve)
+	if routes, ok := router.(WebRoutes); ok {
+		if table := GetRouteTable(s.ref()); table != nil {
+			for _, method := range []string{http.MethodGet, http.MethodHead} {
+				table.Add(RouteInfo{
+					Method:     method,
+					Path:       JoinRoutePath(routes.BasePath(), "/*filepath"),
+					Controller: controller.String(),
+					Handler:    "StaticController",
+				})
+			}
+		}
+	}
+}
+
+func (s *StaticController) ref() *dij.DependencyReference {
+	v, _ := s.GetRef(RefKeyForWebDijRef)
+	ref, _ := v.(*dij.DependencyReference)
+	return ref
+}
+
+// applyAttrs applies the attributes of http tag on Config.
+func (s *StaticController) applyAttrs(attrs lg.StructTagAttrs) error {
+	if attr, ok := attrs.FirstAttrsWithKey("ref"); ok {
+		v, exists := s.GetRef(attr.Val)
+		if !exists {
+			return fmt.Errorf("static config(%s) doesn't exist in DependentRefs", attr.Val)
+		}
+		switch c := v.(type) {
+		case StaticConfig:
+			s.Config = c
+		case *StaticConfig:
+			s.Config = *c
+		case fs.FS:
+			s.Config.FS = c
+		default:
+			return fmt.Errorf("static config(%s) should be StaticConfig or fs.FS, but got %T", attr.Val, v)
+		}
+	}
+	if attr, ok := attrs.FirstAttrsWithKey("dir"); ok {
+		s.Config.Dir = attr.Val
+	}
+	if attr, ok := attrs.FirstAttrsWithKey("root"); ok {
+		s.Config.Root = attr.Val
+	}
+	if attr, ok := attrs.FirstAttrsWithKey("index"); ok {
+		s.Config.IndexFiles = lg.FilterAndMap(strings.Split(attr.Val, "&"), func(v string) (outData string, is bool) {
+			outData = strings.TrimSpace(v)
+			is = len(outData) > 0
+			return
+		})
+	}
+	if attrs.ContainsAttrWithValOnly("listing") {
+		s.Config.Listing = true
+	}
+	if attr, ok := attrs.FirstAttrsWithKey("max-age"); ok {
+		if seconds, err := strconv.Atoi(attr.Val); err == nil && seconds >= 0 {
+			s.Config.MaxAge = time.Duration(seconds) * time.Second
+		} else if d, err := time.ParseDuration(attr.Val); err == nil && d >= 0 {
+			s.Config.MaxAge = d
+		} else {
+			return fmt.Errorf("max-age(%s) should be seconds or a duration", attr.Val)
+		}
+	}
+	return nil
+}
+
+// open returns the file system of Dir or FS.
+func (c *StaticConfig) open() (fs.FS, error) {
+	var fsys fs.FS
+	if len(c.Dir) > 0 {
+		if info, err := os.Stat(c.Dir); err != nil {
+			return nil, err
+		} else if !info.IsDir() {
+			return nil, fmt.Errorf("static dir(%s) is not a directory", c.Dir)
+		}
+		fsys = os.DirFS(c.Dir)
+	} else if c.FS != nil {
+		fsys = c.FS
+	} else {
+		return nil, errors.New("static controller needs a dir or a FS")
+	}
+	if root := strings.Trim(c.Root, "/"); len(root) > 0 && root != "." {
+		sub, err := fs.Sub(fsys, root)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = fs.Stat(sub, "."); err != nil {
+			return nil, fmt.Errorf("static root(%s) doesn't exist: %w", c.Root, err)
+		}
+		fsys = sub
+	}
+	if len(c.IndexFiles) == 0 {
+		c.IndexFiles = []string{"index.html"}
+	}
+	return fsys, nil
+}
+
+// cleanStaticPath converts url path to the name in FS, ok is false for hidden files.
+func cleanStaticPath(urlPath string) (name string, ok bool) {
+	name = strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if len(name) == 0 {
+		return ".", true
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return "", false
+		}
+	}
+	return name, fs.ValidPath(name)
+}
+
+func (s *StaticController) serve(c *gin.Context) {
+	name, ok := cleanStaticPath(c.Param("filepath"))
+	if !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	s.serveName(c, name)
+}
+
+// serveName serves the file or directory with name in FS.
+func (s *StaticController) serveName(c *gin.Context, name string) {
+	info, err := fs.Stat(s.fsys, name)
+	if err != nil {
+		c.AbortWithStatus(lg.Ife(errors.Is(err, fs.ErrNotExist), http.StatusNotFound, http.StatusInternalServerError))
+		return
+	}
+	if !info.IsDir() {
+		s.serveFile(c, name)
+		return
+	}
+	if !strings.HasSuffix(c.Request.URL.Path, "/") {
+		// relative links in index file or listing need the trailing slash.
+		c.Redirect(http.StatusMovedPermanently, c.Request.URL.Path+"/")
+		return
+	}
+	for _, index := range s.Config.IndexFiles {
+		indexName := path.Join(name, index)
+		if info, err := fs.Stat(s.fsys, indexName); err == nil && !info.IsDir() {
+			s.serveFile(c, indexName)
+			return
+		}
+	}
+	if !s.Config.Listing {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	s.serveListing(c, name)
+}
+
+func (s *StaticController) serveFile(c *gin.Context, name string) {
+	f, err := s.fsys.Open(name)
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		content = bytes.NewReader(data)
+	}
+	etag, err := s.etag(name, info, content)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	header := c.Writer.Header()
+	header.Set("ETag", etag)
+	header.Set("Cache-Control", s.cacheControl(name))
+	// ServeContent deals with If-None-Match, If-Modified-Since, Range and Content-Type.
+	http.ServeContent(c.Writer, c.Request, info.Name(), info.ModTime(), content)
+}
+
+// etag returns a weak etag from size and modification time, or a strong etag from the hash of content if modification time is zero.
+func (s *StaticController) etag(name string, info fs.FileInfo, content io.ReadSeeker) (string, error) {
+	if !info.ModTime().IsZero() {
+		return fmt.Sprintf(`W/"%x-%x"`, info.Size(), info.ModTime().UnixNano()), nil
+	}
+	if v, ok := s.etags.Load(name); ok {
+		return v.(string), nil
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	etag := `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+	s.etags.Store(name, etag)
+	return etag, nil
+}
+
+func (s *StaticController) cacheControl(name string) string {
+	if s.Config.CacheControl != nil {
+		if v := s.Config.CacheControl(name); len(v) > 0 {
+			return v
+		}
+	}
+	if s.Config.MaxAge > 0 {
+		return fmt.Sprintf("public, max-age=%d", int(s.Config.MaxAge.Seconds()))
+	}
+	return "no-cache"
+}
+
+func (s *StaticController) serveListing(c *gin.Context, name string) {
+	entries, err := fs.ReadDir(s.fsys, name)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	var b strings.Builder
+	b.WriteString("<!doctype html>\n<meta name=\"viewport\" content=\"width=device-width\">\n<pre>\n")
+	for _, entry := range entries {
+		entryName := entry.Name()
+		if strings.HasPrefix(entryName, ".") {
+			continue
+		}
+		if entry.IsDir() {
+			entryName += "/"
+		}
+		link := url.URL{Path: entryName}
+		b.WriteString(fmt.Sprintf("<a href=\"%s\">%s</a>\n", link.String(), html.EscapeString(entryName)))
+	}
+	b.WriteString("</pre>\n")
+	c.Header("Cache-Control", "no-cache")
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(b.String()))
+}
+
+// parseSetupRouterArgs retrieves the controller instance, attributes of http tag and setup errors from arguments of SetupRouter.
+func parseSetupRouterArgs(others []any) (instPtr any, attrs lg.StructTagAttrs, errs *SetupErrors) {
+	for i, other := range others {
+		switch v := other.(type) {
+		case lg.StructTagAttrs:
+			attrs = v
+		case *SetupErrors:
+			errs = v
+		default:
+			if i == 0 {
+				instPtr = v
+			}
+		}
+	}
+	if errs == nil {
+		errs = &SetupErrors{}
+	}
+	return
+}
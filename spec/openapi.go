@@ -4,7 +4,10 @@
 
 package spec
 
-import "strings"
+import (
+	"strconv"
+	"strings"
+)
 
 type Openapi struct {
 	// REQUIRED. This string MUST be the semantic version number of the OpenAPI Specification version that the OpenAPI document uses.
@@ -68,3 +71,18 @@ func (s *Openapi) AddPathOperation(path string, method string, def Operation) {
 	s.Paths[path] = p
 	// p[method] = def
 }
+
+// UniqueOperationId returns id if no operation uses it, otherwise returns id with the smallest numeric suffix which is not used, ex: getFile2.
+func (s *Openapi) UniqueOperationId(id string) string {
+	used := map[string]bool{}
+	for _, p := range s.Paths {
+		for _, op := range p.Operations() {
+			used[op.OperationID] = true
+		}
+	}
+	unique := id
+	for n := 2; used[unique]; n++ {
+		unique = id + strconv.Itoa(n)
+	}
+	return unique
+}
@@ -4,6 +4,13 @@
 
 package spec
 
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
 // Operation describes a single API operation on a path.
 //
 //	{
@@ -118,4 +125,38 @@ type Operation struct {
 	// If an alternative server object is specified at the Path Item Object or Root level,
 	// it will be overridden by this value.
 	Servers []Server `json:"servers,omitempty"`
+
+	// Specification extensions, the field name MUST begin with "x-". ex: "x-internal-id": "abc".
+	Extensions map[string]any `json:"-"`
+}
+
+// SetExtension sets a specification extension, the key should begin with "x-".
+func (o *Operation) SetExtension(key string, value any) {
+	if !strings.HasPrefix(key, "x-") {
+		key = "x-" + key
+	}
+	if o.Extensions == nil {
+		o.Extensions = map[string]any{}
+	}
+	o.Extensions[key] = value
+}
+
+// MarshalJSON appends extensions after the fixed fields.
+func (o Operation) MarshalJSON() ([]byte, error) {
+	type operation Operation
+	data, err := json.Marshal(operation(o))
+	if err != nil || len(o.Extensions) == 0 {
+		return data, err
+	}
+	ext, err := json.Marshal(o.Extensions)
+	if err != nil {
+		return nil, fmt.Errorf("marshal operation extensions error: %w", err)
+	}
+	// both are json objects, merge "{a}" and "{b}" to "{a,b}"
+	buf := bytes.NewBuffer(data[:len(data)-1])
+	if len(data) > 2 {
+		buf.WriteByte(',')
+	}
+	buf.Write(ext[1:])
+	return buf.Bytes(), nil
 }
@@ -170,7 +170,8 @@ type WithSchemaAndStyle struct {
 
 	// When this is true, parameter values of type array or object generate separate parameters for each value of the array or key-value pair of the map.
 	// For other types of parameters this property has no effect. When style is form, the default value is true. For all other styles, the default value is false.
-	Explode bool `json:"explode,omitempty"`
+	// Nil means the default value.
+	Explode *bool `json:"explode,omitempty"`
 
 	// Determines whether the parameter value SHOULD allow reserved characters, as defined by RFC3986 :/?#[]@!$&'()*+,;= to be included without percent-encoding.
 	// This property only applies to parameters with an in value of query. The default value is false.
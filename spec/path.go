@@ -4,6 +4,8 @@
 
 package spec
 
+import "strings"
+
 // Paths Holds the relative paths to the individual endpoints and their operations.
 // The path is appended to the URL from the Server Object in order to construct the full URL. The Paths MAY be empty, due to ACL constraints.
 //
@@ -131,3 +133,37 @@ type Path struct {
 	// The list can use the Reference Object to link to parameters that are defined at the OpenAPI Object's components/parameters.
 	Parameters ParameterList `json:"parameters,omitempty"`
 }
+
+// Operations returns all defined operations of the path.
+func (p Path) Operations() []*Operation {
+	ops := make([]*Operation, 0)
+	for _, op := range []*Operation{p.Get, p.Put, p.Post, p.Delete, p.Options, p.Head, p.Patch, p.Trace} {
+		if op != nil {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+// Operation returns the operation of method, nil if it isn't defined.
+func (p Path) Operation(method string) *Operation {
+	switch strings.ToLower(method) {
+	case "get":
+		return p.Get
+	case "put":
+		return p.Put
+	case "post":
+		return p.Post
+	case "delete":
+		return p.Delete
+	case "options":
+		return p.Options
+	case "head":
+		return p.Head
+	case "patch":
+		return p.Patch
+	case "trace":
+		return p.Trace
+	}
+	return nil
+}
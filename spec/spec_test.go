@@ -25,3 +25,32 @@ func TestRef(t *testing.T) {
 		}
 	})
 }
+
+// go test ./spec/ -v -run TestOperationExtensions
+func TestOperationExtensions(t *testing.T) {
+	op := Operation{Responses: Responses{}}
+	op.SetExtension("x-concurrency-limit", 10)
+	op.SetExtension("rate", "5/s")
+	data, err := json.Marshal(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"responses":{},"x-concurrency-limit":10,"x-rate":"5/s"}`
+	if string(data) != expected {
+		t.Errorf("%s != %s", data, expected)
+	}
+}
+
+// go test ./spec/ -v -run TestUniqueOperationId
+func TestUniqueOperationId(t *testing.T) {
+	s := Openapi{}
+	for _, path := range []string{"/avatars", "/attachments", "/files"} {
+		s.AddPathOperation(path, "get", Operation{OperationID: s.UniqueOperationId("getFile")})
+	}
+	expected := map[string]string{"/avatars": "getFile", "/attachments": "getFile2", "/files": "getFile3"}
+	for path, id := range expected {
+		if op := s.Paths[path].Get; op == nil || op.OperationID != id {
+			t.Errorf("operationId of %s should be %s, but got %v", path, id, op)
+		}
+	}
+}
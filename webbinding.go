@@ -0,0 +1,513 @@
+// Copyright 2022 Yuchi Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package dij_gin
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"github.com/letscool/lc-go/lg"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// bindingPlan binds a request into the base param of a handler. It's compiled once per handler,
+// so requests don't walk the tags or guess the converters of fields again.
+type bindingPlan struct {
+	baseParamType reflect.Type
+	fields        []fieldBinding
+	streams       []int // indexes of fields with FileStream, they are closed after the handler returns
+	maxBodySize   int64 // max bytes of request body if every file field has limits, 0 means unlimited
+}
+
+type bindingKind int
+
+const (
+	bindContext      bindingKind = iota // the embedded WebContext
+	bindStruct                          // struct, bound by gin with the content type of request
+	bindStructPtr                       // pointer of struct, bound by gin with the content type of request
+	bindValue                           // value of a key, converted from text
+	bindOptions                         // options of a parameterized middleware
+	bindProvide                         // Provide field of a middleware handler
+	bindContextValue                    // value set into gin context by a middleware
+	bindFile                            // uploaded files of multipart form
+)
+
+type fieldBinding struct {
+	index    int
+	name     string // field name
+	key      string // key of request value
+	in       InWay  // way to look up the request value, empty means guessing
+	way      InWay  // resolved way of the parameter in errors, it's resolved by request method for structs
+	kind     bindingKind
+	exported bool
+	convert  valueConverter
+	provide  reflect.Value // Provide value with the key
+	missing  int           // status code if the context value is missing
+	required bool          // the request fails if the value is missing
+	fallback reflect.Value // default value if the value is missing
+	limits   fileLimits    // limits of uploaded files
+	// styled parameters, ex: arrays, objects and primitives with style attribute. convert is for items of array.
+	styled    bool
+	shape     paramShape
+	style     paramStyle
+	props     []propBinding
+	propNames []string
+}
+
+// bindingFailure is responded instead of calling the handler.
+type bindingFailure struct {
+	status int
+	err    WebError
+}
+
+// newBadRequestFailure returns the failure of 400 with details of parameters.
+func newBadRequestFailure(details []ParamError) *bindingFailure {
+	messages := lg.Map(details, func(d ParamError) string { return d.Error() })
+	return &bindingFailure{http.StatusBadRequest, WebError{
+		Message: strings.Join(messages, "\n"),
+		Code:    strconv.Itoa(http.StatusBadRequest),
+		Details: details,
+	}}
+}
+
+// validationError converts the error of validator into WebError, field errors are listed in details with
+// the names and ways of parameters like binding failures.
+func (p *bindingPlan) validationError(ctx WebContext, err error) WebError {
+	webErr := ToWebError(err, strconv.Itoa(http.StatusBadRequest))
+	var fieldErrs validator.ValidationErrors
+	if !errors.As(err, &fieldErrs) {
+		return webErr
+	}
+	for _, fe := range fieldErrs {
+		detail := ParamError{
+			Name:    fe.Field(),
+			Type:    fe.Type().String(),
+			Message: fe.Error(),
+		}
+		if fe.Kind() != reflect.Struct {
+			detail.Value = fmt.Sprint(fe.Value())
+		}
+		// namespace is like "Field.Nested", it's prefixed by the name of base param type if the type isn't anonymous.
+		namespace := strings.TrimPrefix(fe.StructNamespace(), p.baseParamType.Name()+".")
+		names := strings.SplitN(namespace, ".", 2)
+		for i := range p.fields {
+			if fb := &p.fields[i]; fb.name == names[0] {
+				if detail.In = fb.wayOf(ctx); len(names) == 1 {
+					detail.Name = fb.key
+				} else if fb.kind == bindValue {
+					detail.Name = fb.key + "." + names[1]
+				} else {
+					detail.Name = names[1]
+				}
+				break
+			}
+		}
+		webErr.Details = append(webErr.Details, detail)
+	}
+	return webErr
+}
+
+// valueConverter parses the text into dst, dst is addressable and settable.
+type valueConverter func(text string, dst reflect.Value) error
+
+func compileBindingPlan(hdlSpec *HandlerSpec) *bindingPlan {
+	plan := &bindingPlan{baseParamType: hdlSpec.BaseParamType}
+	for _, def := range hdlSpec.InFields {
+		fieldSpec := def.FieldSpec
+		fb := fieldBinding{
+			index:    def.Index,
+			name:     fieldSpec.Name,
+			key:      def.PreferredName,
+			exported: fieldSpec.IsExported(),
+		}
+		switch typ := fieldSpec.Type; {
+		case fieldSpec.Anonymous && typ == WebCtxType:
+			fb.kind = bindContext
+		case len(fb.name) == 0 || fb.name[0] == '_':
+			continue // ignore
+		case hdlSpec.Options != nil && def.Index == hdlSpec.Options.Index:
+			fb.kind = bindOptions
+		case isContextProvider(typ):
+			fb.kind = bindProvide
+			fb.provide = newContextProvider(typ, def.PreferredName)
+		case isInContextWay(def):
+			fb.kind = bindContextValue
+			fb.missing, _ = missingStatusOf(def)
+		case isFileType(typ):
+			fb.kind = bindFile
+			fb.way = InBodyWay
+			fb.required = def.Attrs.ContainsAttrWithValOnly("required")
+			fb.limits, _ = fileLimitsOf(def)
+			if n := fb.limits.maxBytes(typ); n > 0 && plan.maxBodySize >= 0 {
+				plan.maxBodySize += n
+			} else {
+				plan.maxBodySize = -1
+			}
+			if typ == fileStreamType || typ.Elem() == fileStreamType {
+				plan.streams = append(plan.streams, len(plan.fields))
+			}
+		case isStructBinding(def):
+			fb.kind = lg.Ife(typ.Kind() == reflect.Pointer, bindStructPtr, bindStruct)
+			fb.required = def.Attrs.ContainsAttrWithValOnly("required")
+		default:
+			fb.kind = bindValue
+			if in, ok := def.Attrs.FirstAttrsWithKey("in"); ok {
+				fb.in = in.Val
+			}
+			fb.convert = newValueConverter(typ)
+			fb.shape = paramShapeOf(typ)
+			fb.required = def.Attrs.ContainsAttrWithValOnly("required")
+			fb.fallback, _, _ = defaultValueOf(def)
+			// the way of styled parameter should be resolved, it can't be guessed by request.
+			fb.way = hdlSpec.inWayOf(def)
+			style, explicit, _ := paramStyleOf(def, fb.way)
+			if fb.styled = explicit || fb.shape != shapePrimitive; fb.styled {
+				fb.in, fb.style = fb.way, style
+				switch fb.shape {
+				case shapeArray:
+					fb.convert = newValueConverter(typ.Elem())
+				case shapeObject:
+					fb.props = propBindingsOf(typ)
+					fb.propNames = lg.Map(fb.props, func(p propBinding) string { return p.name })
+				}
+			}
+		}
+		plan.fields = append(plan.fields, fb)
+	}
+	if plan.maxBodySize > 0 {
+		plan.maxBodySize += multipartFormAllowance
+	} else {
+		plan.maxBodySize = 0
+	}
+	return plan
+}
+
+// bind creates the base param and sets its fields from the request, it returns the pointer of base param.
+// The options are set into the options field of a middleware handler if they are valid.
+// A failure is returned if a context value is missing, or any field fails to bind, the handler shouldn't be called.
+func (p *bindingPlan) bind(ctx WebContext, methodName string, options reflect.Value) (reflect.Value, *bindingFailure) {
+	instPtrVal := reflect.New(p.baseParamType)
+	instVal := instPtrVal.Elem()
+	if p.maxBodySize > 0 && ctx.Request.Body != nil {
+		// the body isn't read over the limit, so oversized files aren't buffered before they are checked.
+		ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, p.maxBodySize)
+	}
+	var details []ParamError
+	for i := range p.fields {
+		fb := &p.fields[i]
+		field := instVal.Field(fb.index)
+		if !fb.exported {
+			field = reflect.NewAt(field.Type(), field.Addr().UnsafePointer()).Elem()
+		}
+		switch fb.kind {
+		case bindContext:
+			field.Set(reflect.ValueOf(ctx))
+		case bindStruct:
+			if err := ctx.ShouldBind(field.Addr().Interface()); err == nil {
+			} else if !errors.Is(err, io.EOF) {
+				details = append(details, fb.bindError(ctx, field.Type(), "", err))
+			} else if fb.required {
+				// the struct is zero if the body is empty, unless it's required
+				details = append(details, fb.bindError(ctx, field.Type(), "", errRequired))
+			}
+		case bindStructPtr:
+			value := reflect.New(field.Type().Elem())
+			if err := ctx.ShouldBind(value.Interface()); err == nil {
+				field.Set(value)
+			} else if !errors.Is(err, io.EOF) {
+				details = append(details, fb.bindError(ctx, field.Type(), "", err))
+			} else if fb.required {
+				// the pointer is nil if the body is empty, unless it's required
+				details = append(details, fb.bindError(ctx, field.Type(), "", errRequired))
+			}
+		case bindOptions:
+			if options.IsValid() {
+				field.Set(options)
+			}
+		case bindProvide:
+			field.Set(fb.provide)
+		case bindContextValue:
+			value, exists := ctx.Get(fb.key)
+			if !exists || value == nil {
+				return instPtrVal, &bindingFailure{fb.missing, WebError{
+					Message: fmt.Sprintf("context value '%s' is missing", fb.key),
+					Code:    strconv.Itoa(fb.missing),
+				}}
+			}
+			v := reflect.ValueOf(value)
+			if !v.Type().AssignableTo(field.Type()) {
+				ctx.Logger().Log(LogError, "context value type mismatch", LogKV("method", methodName), LogKV("key", fb.key),
+					LogKV("type", v.Type()), LogKV("expected", field.Type()))
+				return instPtrVal, &bindingFailure{http.StatusInternalServerError, WebError{
+					Message: fmt.Sprintf("context value '%s' is %v, not %v", fb.key, v.Type(), field.Type()),
+					Code:    strconv.Itoa(http.StatusInternalServerError),
+				}}
+			}
+			field.Set(v)
+		case bindFile:
+			files, err := formFiles(ctx, fb.key)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				return instPtrVal, &bindingFailure{http.StatusRequestEntityTooLarge, WebError{
+					Message: fmt.Sprintf("request body is too large, the limit is %d bytes", tooLarge.Limit),
+					Code:    strconv.Itoa(http.StatusRequestEntityTooLarge),
+				}}
+			}
+			if err == nil && len(files) > 0 {
+				if err = fb.limits.check(files); err == nil {
+					err = assignFiles(files, field)
+				}
+			}
+			if err != nil {
+				details = append(details, fb.bindError(ctx, field.Type(), "", err))
+			} else if len(files) == 0 && fb.required {
+				details = append(details, fb.bindError(ctx, field.Type(), "", errRequired))
+			}
+		case bindValue:
+			exists := false
+			if fb.styled {
+				var raw rawParam
+				if raw, exists = fb.decode(ctx); exists {
+					if text, err := fb.assign(raw, field); err != nil {
+						details = append(details, fb.bindError(ctx, field.Type(), text, err))
+					}
+				}
+			} else {
+				var text string
+				if text, exists = ctx.getRequestText(fb.key, fb.in); exists {
+					if err := fb.convert(text, field); err != nil {
+						details = append(details, fb.bindError(ctx, field.Type(), text, err))
+					}
+				}
+			}
+			if !exists && fb.required {
+				details = append(details, fb.bindError(ctx, field.Type(), "", errRequired))
+			} else if !exists && fb.fallback.IsValid() {
+				fb.setDefault(field)
+			}
+		}
+	}
+	if len(details) > 0 {
+		ctx.Logger().Log(LogWarn, "bind request error", LogKV("method", methodName), LogKV("error", details[0]))
+		return instPtrVal, newBadRequestFailure(details)
+	}
+	return instPtrVal, nil
+}
+
+// release closes the opened files of the base param.
+func (p *bindingPlan) release(instPtrVal reflect.Value) {
+	for _, i := range p.streams {
+		field := instPtrVal.Elem().Field(p.fields[i].index)
+		if !p.fields[i].exported {
+			field = reflect.NewAt(field.Type(), field.Addr().UnsafePointer()).Elem()
+		}
+		closeFiles(field)
+	}
+}
+
+var errRequired = errors.New("required value is missing")
+
+// setDefault sets the default value into field, the slice is copied so the handler can't change the default value.
+func (fb *fieldBinding) setDefault(field reflect.Value) {
+	if fb.fallback.Kind() == reflect.Slice {
+		field.Set(reflect.AppendSlice(reflect.MakeSlice(fb.fallback.Type(), 0, fb.fallback.Len()), fb.fallback))
+	} else {
+		field.Set(fb.fallback)
+	}
+}
+
+// defaultValueOf converts the `default=` attribute into the type of field, items of array are joined by '&',
+// ex: `default=1&2`. Objects don't support default values.
+func defaultValueOf(def BaseParamField) (value reflect.Value, exists bool, err error) {
+	attr, ok := def.Attrs.FirstAttrsWithKey("default")
+	if !ok {
+		return
+	}
+	typ := def.FieldSpec.Type
+	value = reflect.New(typ).Elem()
+	switch paramShapeOf(typ) {
+	case shapeObject:
+		return reflect.Value{}, true, fmt.Errorf("default value isn't supported by %v", typ)
+	case shapeArray:
+		fb := fieldBinding{shape: shapeArray, convert: newValueConverter(typ.Elem())}
+		_, err = fb.assign(rawParam{items: splitItems(attr.Val, "&")}, value)
+	default:
+		err = newValueConverter(typ)(attr.Val, value)
+	}
+	if err != nil {
+		return reflect.Value{}, true, fmt.Errorf("default(%s) can't be converted to %v: %w", attr.Val, typ, err)
+	}
+	return value, true, nil
+}
+
+// bindError returns the detail of a field which fails to bind, text is the raw value.
+func (fb *fieldBinding) bindError(ctx WebContext, typ reflect.Type, text string, err error) ParamError {
+	return ParamError{
+		Name:    fb.key,
+		In:      fb.wayOf(ctx),
+		Value:   text,
+		Type:    typ.String(),
+		Message: err.Error(),
+	}
+}
+
+// wayOf returns the way of parameter in errors, gin binds struct from query for GET, otherwise from body.
+func (fb *fieldBinding) wayOf(ctx WebContext) InWay {
+	if fb.kind == bindStruct || fb.kind == bindStructPtr {
+		return lg.Ife(ctx.Request.Method == http.MethodGet, InQueryWay, InBodyWay)
+	}
+	return fb.way
+}
+
+// isStructBinding reports whether the field is a struct or a pointer of struct, which is bound by gin with
+// the content type of request.
+func isStructBinding(def BaseParamField) bool {
+	typ := def.FieldSpec.Type
+	if typ.Kind() == reflect.Pointer {
+		return typ.Elem().Kind() == reflect.Struct && !isTextValue(typ.Elem())
+	}
+	return typ.Kind() == reflect.Struct && !isTextValue(typ) && !isObjectParam(def)
+}
+
+func isInContextWay(def BaseParamField) bool {
+	attr, ok := def.Attrs.FirstAttrsWithKey("in")
+	return ok && normalizeInWay(attr.Val) == InContextWay
+}
+
+// newValueConverter returns the converter of type. Types implementing encoding.TextUnmarshaler are parsed by themselves,
+// ex: time.Time in RFC 3339, basic kinds are parsed by strconv, and others are unmarshalled from json.
+func newValueConverter(typ reflect.Type) valueConverter {
+	if isTextValue(typ) {
+		return func(text string, dst reflect.Value) error {
+			return dst.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(text))
+		}
+	}
+	switch typ.Kind() {
+	case reflect.Pointer:
+		convert := newValueConverter(typ.Elem())
+		return func(text string, dst reflect.Value) error {
+			value := reflect.New(typ.Elem())
+			if err := convert(text, value.Elem()); err != nil {
+				return err
+			}
+			dst.Set(value)
+			return nil
+		}
+	case reflect.String:
+		return func(text string, dst reflect.Value) error {
+			dst.SetString(text)
+			return nil
+		}
+	case reflect.Bool:
+		return func(text string, dst reflect.Value) error {
+			b, err := strconv.ParseBool(text)
+			dst.SetBool(b)
+			return err
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		bits := typ.Bits()
+		return func(text string, dst reflect.Value) error {
+			n, err := strconv.ParseInt(text, 10, bits)
+			if err == nil {
+				dst.SetInt(n)
+			}
+			return err
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		bits := typ.Bits()
+		return func(text string, dst reflect.Value) error {
+			n, err := strconv.ParseUint(text, 10, bits)
+			if err == nil {
+				dst.SetUint(n)
+			}
+			return err
+		}
+	case reflect.Float32, reflect.Float64:
+		bits := typ.Bits()
+		return func(text string, dst reflect.Value) error {
+			f, err := strconv.ParseFloat(text, bits)
+			if err == nil {
+				dst.SetFloat(f)
+			}
+			return err
+		}
+	default:
+		return func(text string, dst reflect.Value) error {
+			return json.Unmarshal([]byte(text), dst.Addr().Interface())
+		}
+	}
+}
+
+// handlerCacheKey is the key of analyzed handlers, handlers of a type are analyzed once for each purpose.
+type handlerCacheKey struct {
+	instPtrType reflect.Type
+	purpose     HandlerWrapperPurpose
+}
+
+// analyzedHandler is the result of analyzing a handler method, it's shared by all instances of the type,
+// so it should never be changed after analyzing.
+type analyzedHandler struct {
+	methodIndex int
+	spec        HandlerSpec
+	plan        *bindingPlan // nil if the base param is WebContext
+	errs        SetupErrors  // errors of analyzing, the handler is skipped if any
+}
+
+var handlerCache sync.Map // handlerCacheKey -> []analyzedHandler
+
+// analyzeHandlers analyzes handler methods of the type, the result is cached for mounts and dynamic routes which
+// create instances of the same type.
+func analyzeHandlers(instPtrType reflect.Type, purpose HandlerWrapperPurpose) []analyzedHandler {
+	key := handlerCacheKey{instPtrType, purpose}
+	if v, ok := handlerCache.Load(key); ok {
+		return v.([]analyzedHandler)
+	}
+	handlers := make([]analyzedHandler, 0)
+	handleMethodRegex := purpose.Regexp()
+	for i := 0; i < instPtrType.NumMethod(); i++ {
+		method := instPtrType.Method(i)
+		if !method.IsExported() {
+			continue
+		}
+		methodType := method.Type
+		if methodType.NumIn() != 2 || methodType.NumOut() > 1 {
+			continue
+		}
+		baseParamType := methodType.In(1)
+		if !IsTypeOfWebContext(baseParamType) || baseParamType.Kind() != reflect.Struct {
+			continue
+		}
+		// Only fit function with one parameter and the parameter extends WebContext.
+		h := analyzedHandler{
+			methodIndex: i,
+			spec: HandlerSpec{
+				Purpose:       purpose,
+				BaseParamType: baseParamType,
+				MethodType:    method,
+			},
+		}
+		lowerMethodName := strings.ToLower(method.Name)
+		h.spec.Method = string(handleMethodRegex.Find([]byte(lowerMethodName)))
+		h.spec.Path = lowerMethodName[len(h.spec.Method):]
+		if purpose == HandlerForReq && methodType.NumOut() == 1 {
+			analyzeOutBaseParam(methodType.Out(0), purpose, &h.spec, &h.errs)
+		}
+		if baseParamType != WebCtxType {
+			// this part extends WebContext, so it also should process tag information
+			analyzeInBaseParam(baseParamType, purpose, &h.spec, &h.errs)
+			h.plan = compileBindingPlan(&h.spec)
+		}
+		handlers = append(handlers, h)
+	}
+	v, _ := handlerCache.LoadOrStore(key, handlers)
+	return v.([]analyzedHandler)
+}
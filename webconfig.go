@@ -5,16 +5,20 @@
 package dij_gin
 
 import (
+	"fmt"
+	"github.com/gin-gonic/gin"
 	"github.com/letscool/dij-gin/spec"
 	"github.com/letscool/lc-go/lg"
 	"io"
+	"net/http"
 	"os"
-	"strings"
+	"time"
 )
 
 const (
 	DefaultWebServerPort    = 8000
 	DefaultValidatorTagName = "validate"
+	DefaultShutdownTimeout  = 10 * time.Second
 )
 
 type RuntimeEnv string
@@ -26,23 +30,33 @@ const (
 	RtTest  RuntimeEnv = "test"
 )
 
+// IsInOnlyEnv checks the environment with the env expression, environments are separated by '&' and
+// prefix '!' means exclusion, ex: "dev&test" means dev or test, "!prod" means any environment except prod.
+// An empty expression means all environments.
 func (r RuntimeEnv) IsInOnlyEnv(onlyEnv string) bool {
-	if onlyEnv = strings.TrimSpace(onlyEnv); len(onlyEnv) == 0 {
-		return true
-	}
-	return lg.Contains(strings.Split(onlyEnv, "&"), string(r))
+	return matchEnvExpr(onlyEnv, []RuntimeEnv{r})
 }
 
 type WebConfig struct {
-	Address          string // default is localhost
-	Port             int    // if not setting, 8000 will be used.
-	MaxConn          int
-	BasePath         string // Default is empty
-	ValidatorTagName string // Default is "validate", but go-gin preferred "binding".
-	DependentRefs    map[string]any
-	RtEnv            RuntimeEnv // Default is "dev"
-	OpenApi          OpenApiConfig
-	DefaultWriter    io.Writer
+	Address           string // default is localhost
+	Port              int    // if not setting, 8000 will be used.
+	MaxConn           int    // max concurrent connections, zero means unlimited.
+	BasePath          string // Default is empty
+	ValidatorTagName  string // Default is "validate", but go-gin preferred "binding".
+	DependentRefs     map[string]any
+	RtEnv             RuntimeEnv // Default is "dev"
+	Envs              map[RuntimeEnv]*EnvConfig
+	OpenApi           OpenApiConfig
+	Tls               TlsConfig
+	Engine            EngineConfig
+	Template          TemplateConfig
+	Mounts            map[string]*MountConfig    // settings of mounted controllers by mount name
+	Middlewares       map[string]gin.HandlerFunc // middlewares by name, they are used in http tags like middleware handlers.
+	GlobalMiddlewares []string                   // middlewares wrap all routes including NoRoute and NoMethod, ex: "log", "ratelimit(100/s)".
+	DefaultWriter     io.Writer
+	ShutdownTimeout   time.Duration // grace period for draining in-flight requests, default is 10 seconds.
+	Logger            WebLogger     // Default writes to DefaultWriter with info level, and is silent in prod runtime environment.
+	FlagOffStatus     int           // status code responded when a feature flag of the route is off, default is 404.
 }
 
 // NewWebConfig returns an instance with default values.
@@ -66,13 +80,22 @@ func (c *WebConfig) ApplyDefaultValues() {
 	if c.RtEnv == "" {
 		c.RtEnv = RtDev
 	}
+	c.applyEnvOverlays()
 	if c.DependentRefs == nil {
 		c.DependentRefs = map[string]any{}
 	}
 	if c.DefaultWriter == nil {
 		c.DefaultWriter = os.Stdout
 	}
+	if c.ShutdownTimeout <= 0 {
+		c.ShutdownTimeout = DefaultShutdownTimeout
+	}
+	if c.FlagOffStatus <= 0 {
+		c.FlagOffStatus = http.StatusNotFound
+	}
 	c.OpenApi.ApplyDefaultValues()
+	c.Tls.ApplyDefaultValues()
+	c.Template.ApplyDefaultValues()
 	if c.OpenApi.Address == "" {
 		c.OpenApi.Address = lg.Ife(c.Address == "", "localhost", c.Address)
 	}
@@ -81,6 +104,20 @@ func (c *WebConfig) ApplyDefaultValues() {
 	}
 }
 
+// GetLogger returns the logger for framework, a new default logger is returned if Logger isn't set.
+// PrepareGin calls it once with the final config and shares the logger by RefKeyForWebLogger.
+func (c *WebConfig) GetLogger() WebLogger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return NewWebLogger(lg.Ife[io.Writer](c.DefaultWriter == nil, os.Stdout, c.DefaultWriter), lg.Ife(c.BaseEnv() == RtProd, LogSilent, LogInfo))
+}
+
+// ServerAddr returns the address for listening, ex: "localhost:8000".
+func (c *WebConfig) ServerAddr() string {
+	return fmt.Sprintf("%v:%d", c.Address, lg.Ife(c.Port <= 0, DefaultWebServerPort, c.Port))
+}
+
 func (c *WebConfig) SetRtMode(mode RuntimeEnv) *WebConfig {
 	c.RtEnv = mode
 	return c
@@ -96,6 +133,11 @@ func (c *WebConfig) SetPort(port int) *WebConfig {
 	return c
 }
 
+func (c *WebConfig) SetMaxConn(n int) *WebConfig {
+	c.MaxConn = n
+	return c
+}
+
 func (c *WebConfig) SetBasePath(path string) *WebConfig {
 	c.BasePath = path
 	return c
@@ -106,6 +148,51 @@ func (c *WebConfig) SetOpenApi(f func(o *OpenApiConfig)) *WebConfig {
 	return c
 }
 
+func (c *WebConfig) SetTls(f func(t *TlsConfig)) *WebConfig {
+	f(&c.Tls)
+	return c
+}
+
+func (c *WebConfig) SetEngine(f func(e *EngineConfig)) *WebConfig {
+	f(&c.Engine)
+	return c
+}
+
+func (c *WebConfig) SetTemplate(f func(t *TemplateConfig)) *WebConfig {
+	f(&c.Template)
+	return c
+}
+
+// SetMount overrides the settings of a mounted controller, the name is the value of `mount=name` attribute or the field name.
+func (c *WebConfig) SetMount(name string, f func(m *MountConfig)) *WebConfig {
+	if c.Mounts == nil {
+		c.Mounts = map[string]*MountConfig{}
+	}
+	m, ok := c.Mounts[name]
+	if !ok {
+		m = &MountConfig{}
+		c.Mounts[name] = m
+	}
+	f(m)
+	return c
+}
+
+// SetMiddleware registers a middleware by name, it can be used in http tags of all controllers, ex: `middleware=auth`.
+func (c *WebConfig) SetMiddleware(name string, handler gin.HandlerFunc) *WebConfig {
+	if c.Middlewares == nil {
+		c.Middlewares = map[string]gin.HandlerFunc{}
+	}
+	c.Middlewares[name] = handler
+	return c
+}
+
+// SetGlobalMiddlewares sets middlewares which wrap all routes including NoRoute and NoMethod handlers.
+// The middlewares are registered by SetMiddleware or declared by middleware fields of any controller.
+func (c *WebConfig) SetGlobalMiddlewares(refs ...string) *WebConfig {
+	c.GlobalMiddlewares = append([]string{}, refs...)
+	return c
+}
+
 func (c *WebConfig) SetDependentRef(key string, ref any) *WebConfig {
 	if c.DependentRefs == nil {
 		c.DependentRefs = map[string]any{}
@@ -118,11 +205,31 @@ func (c *WebConfig) SetDependentRef(key string, ref any) *WebConfig {
 //	return c.SetDependentRef("_.mdl.log.formatter", formatter)
 //}
 
+func (c *WebConfig) SetLogger(logger WebLogger) *WebConfig {
+	c.Logger = logger
+	return c
+}
+
 func (c *WebConfig) SetDefaultWriter(writer io.Writer) *WebConfig {
 	c.DefaultWriter = writer
 	return c
 }
 
+// SetFeatureFlagProvider sets the provider for routes with `flag=name` attribute into DependentRefs.
+func (c *WebConfig) SetFeatureFlagProvider(provider FeatureFlagProvider) *WebConfig {
+	return c.SetDependentRef(RefKeyForWebFeatureFlags, provider)
+}
+
+func (c *WebConfig) SetFlagOffStatus(status int) *WebConfig {
+	c.FlagOffStatus = status
+	return c
+}
+
+func (c *WebConfig) SetShutdownTimeout(timeout time.Duration) *WebConfig {
+	c.ShutdownTimeout = timeout
+	return c
+}
+
 type OpenApiConfig struct {
 	Enabled         bool // Default is false
 	Title           string
@@ -0,0 +1,568 @@
+// Copyright 2022 Yuchi Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package dij_gin
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultConfigEnvPrefix = "DIJGIN_"
+
+// ConfigError describes an incorrect value in config file or environment variable.
+type ConfigError struct {
+	Source string // file path, or "env" for environment variables
+	Key    string // ex: "openApi.port" in config file, or "DIJGIN_OPENAPI_PORT"
+	Reason string
+}
+
+func (e *ConfigError) Error() string {
+	if len(e.Source) > 0 {
+		return fmt.Sprintf("%s: %s: %s", e.Source, e.Key, e.Reason)
+	}
+	return fmt.Sprintf("%s: %s", e.Key, e.Reason)
+}
+
+// ConfigErrors collects all config errors, so all incorrect values can be reported in one run.
+type ConfigErrors []*ConfigError
+
+func (e *ConfigErrors) add(source, key string, format string, args ...any) {
+	*e = append(*e, &ConfigError{Source: source, Key: key, Reason: fmt.Sprintf(format, args...)})
+}
+
+// configSources records where the values come from, the key is the field key of config, ex: "maxConn",
+// so an incorrect value is reported with the file and key, or the environment variable the user actually set.
+type configSources map[string]ConfigError
+
+func (s configSources) set(fieldKey, source, key string) {
+	s[fieldKey] = ConfigError{Source: source, Key: key}
+}
+
+// add adds the error of field, it's reported with the source of value if the value is loaded.
+func (s configSources) add(errs *ConfigErrors, fieldKey string, format string, args ...any) {
+	if src, ok := s[fieldKey]; ok {
+		errs.add(src.Source, src.Key, format, args...)
+	} else {
+		errs.add("", fieldKey, format, args...)
+	}
+}
+
+// Err returns nil if no error is collected.
+func (e ConfigErrors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e ConfigErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("%d config errors:", len(e)))
+	for i, err := range e {
+		b.WriteString(fmt.Sprintf("\n  %d. %s", i+1, err.Error()))
+	}
+	return b.String()
+}
+
+// Unwrap returns all collected errors.
+func (e ConfigErrors) Unwrap() []error {
+	errs := make([]error, 0, len(e))
+	for _, err := range e {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// ConfigLoader loads WebConfig from files and environment variables.
+// The precedence is: default values < files (in order) < environment variables < setters called after loading.
+//
+// Keys in files are case-insensitive and '_' or '-' are ignored, so "maxConn", "max_conn" and "max-conn" are the same key.
+// The format of file is decided by its extension, .yaml/.yml, .json or .toml.
+//
+//	port: 8080
+//	rtEnv: staging
+//	openApi:
+//	  enabled: true
+//	envs:
+//	  staging:
+//	    base: prod
+//	database:        # a section registered by SetSection("database", &DbConfig{})
+//	  host: db.local
+type ConfigLoader struct {
+	Files     []string
+	EnvPrefix string                          // prefix of environment variables, empty means not loading environment variables.
+	Sections  map[string]any                  // name to pointer of struct, decoded by json tags and set into DependentRefs with the name.
+	LookupEnv func(key string) (string, bool) // default is os.LookupEnv
+}
+
+// NewConfigLoader returns a loader which loads files, and environment variables with prefix "DIJGIN_".
+func NewConfigLoader(files ...string) *ConfigLoader {
+	return &ConfigLoader{
+		Files:     files,
+		EnvPrefix: DefaultConfigEnvPrefix,
+		Sections:  map[string]any{},
+	}
+}
+
+// LoadWebConfig loads WebConfig from files and environment variables with prefix "DIJGIN_".
+func LoadWebConfig(files ...string) (*WebConfig, error) {
+	return NewConfigLoader(files...).Load()
+}
+
+func (l *ConfigLoader) AddFiles(files ...string) *ConfigLoader {
+	l.Files = append(l.Files, files...)
+	return l
+}
+
+func (l *ConfigLoader) SetEnvPrefix(prefix string) *ConfigLoader {
+	l.EnvPrefix = prefix
+	return l
+}
+
+// SetSection registers a section of config file, target should be a pointer of struct.
+// The target is set into DependentRefs with the name even if the section doesn't exist in files.
+func (l *ConfigLoader) SetSection(name string, target any) *ConfigLoader {
+	if l.Sections == nil {
+		l.Sections = map[string]any{}
+	}
+	l.Sections[name] = target
+	return l
+}
+
+func (l *ConfigLoader) SetLookupEnv(f func(key string) (string, bool)) *ConfigLoader {
+	l.LookupEnv = f
+	return l
+}
+
+// Load creates a WebConfig, loads values into it and then applies default values.
+func (l *ConfigLoader) Load() (*WebConfig, error) {
+	config := &WebConfig{}
+	if err := l.LoadInto(config); err != nil {
+		return nil, err
+	}
+	config.ApplyDefaultValues()
+	return config, nil
+}
+
+// LoadInto loads values into config, all incorrect values are returned as ConfigErrors.
+func (l *ConfigLoader) LoadInto(config *WebConfig) error {
+	var errs ConfigErrors
+	sources := configSources{}
+	for _, file := range l.Files {
+		m, err := readConfigFile(file)
+		if err != nil {
+			errs.add(file, "", "%v", err)
+			continue
+		}
+		l.loadMap(config, file, m, sources, &errs)
+	}
+	if len(l.EnvPrefix) > 0 {
+		l.loadEnv(config, sources, &errs)
+	}
+	for name, target := range l.Sections {
+		config.SetDependentRef(name, target)
+	}
+	validateWebConfig(config, sources, &errs)
+	return errs.Err()
+}
+
+type configField struct {
+	key string // path in config file, ex: "openApi.enabled"
+	env string // environment variable without prefix, ex: "OPENAPI_ENABLED"
+	ptr func(c *WebConfig) any
+}
+
+var webConfigFields = []configField{
+	{"address", "ADDRESS", func(c *WebConfig) any { return &c.Address }},
+	{"port", "PORT", func(c *WebConfig) any { return &c.Port }},
+	{"maxConn", "MAX_CONN", func(c *WebConfig) any { return &c.MaxConn }},
+	{"basePath", "BASE_PATH", func(c *WebConfig) any { return &c.BasePath }},
+	{"validatorTagName", "VALIDATOR_TAG_NAME", func(c *WebConfig) any { return &c.ValidatorTagName }},
+	{"rtEnv", "RT_ENV", func(c *WebConfig) any { return &c.RtEnv }},
+	{"shutdownTimeout", "SHUTDOWN_TIMEOUT", func(c *WebConfig) any { return &c.ShutdownTimeout }},
+	{"flagOffStatus", "FLAG_OFF_STATUS", func(c *WebConfig) any { return &c.FlagOffStatus }},
+	{"openApi.enabled", "OPENAPI_ENABLED", func(c *WebConfig) any { return &c.OpenApi.Enabled }},
+	{"openApi.title", "OPENAPI_TITLE", func(c *WebConfig) any { return &c.OpenApi.Title }},
+	{"openApi.description", "OPENAPI_DESCRIPTION", func(c *WebConfig) any { return &c.OpenApi.Description }},
+	{"openApi.version", "OPENAPI_VERSION", func(c *WebConfig) any { return &c.OpenApi.Version }},
+	{"openApi.schemes", "OPENAPI_SCHEMES", func(c *WebConfig) any { return &c.OpenApi.Schemes }},
+	{"openApi.address", "OPENAPI_ADDRESS", func(c *WebConfig) any { return &c.OpenApi.Address }},
+	{"openApi.port", "OPENAPI_PORT", func(c *WebConfig) any { return &c.OpenApi.Port }},
+	{"openApi.docPath", "OPENAPI_DOC_PATH", func(c *WebConfig) any { return &c.OpenApi.DocPath }},
+	{"tls.enabled", "TLS_ENABLED", func(c *WebConfig) any { return &c.Tls.Enabled }},
+	{"tls.certFile", "TLS_CERT_FILE", func(c *WebConfig) any { return &c.Tls.CertFile }},
+	{"tls.keyFile", "TLS_KEY_FILE", func(c *WebConfig) any { return &c.Tls.KeyFile }},
+	{"tls.minVersion", "TLS_MIN_VERSION", func(c *WebConfig) any { return &c.Tls.MinVersion }},
+	{"tls.clientCAFile", "TLS_CLIENT_CA_FILE", func(c *WebConfig) any { return &c.Tls.ClientCAFile }},
+	{"engine.ginMode", "ENGINE_GIN_MODE", func(c *WebConfig) any { return &c.Engine.GinMode }},
+	{"engine.trustedProxies", "ENGINE_TRUSTED_PROXIES", func(c *WebConfig) any { return &c.Engine.TrustedProxies }},
+	{"engine.trustedPlatform", "ENGINE_TRUSTED_PLATFORM", func(c *WebConfig) any { return &c.Engine.TrustedPlatform }},
+	{"engine.remoteIPHeaders", "ENGINE_REMOTE_IP_HEADERS", func(c *WebConfig) any { return &c.Engine.RemoteIPHeaders }},
+	{"template.dir", "TEMPLATE_DIR", func(c *WebConfig) any { return &c.Template.Dir }},
+	{"template.root", "TEMPLATE_ROOT", func(c *WebConfig) any { return &c.Template.Root }},
+	{"template.layout", "TEMPLATE_LAYOUT", func(c *WebConfig) any { return &c.Template.Layout }},
+	{"template.reload", "TEMPLATE_RELOAD", func(c *WebConfig) any { return &c.Template.Reload }},
+}
+
+var envConfigFields = map[string]func(e *EnvConfig) any{
+	"base":           func(e *EnvConfig) any { return &e.Base },
+	"address":        func(e *EnvConfig) any { return &e.Address },
+	"port":           func(e *EnvConfig) any { return &e.Port },
+	"basepath":       func(e *EnvConfig) any { return &e.BasePath },
+	"openapienabled": func(e *EnvConfig) any { return &e.OpenApiEnabled },
+}
+
+// normalizeConfigKey makes keys case-insensitive and ignores '_' and '-'.
+func normalizeConfigKey(key string) string {
+	return strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(key))
+}
+
+func findConfigField(key string) (configField, bool) {
+	for _, f := range webConfigFields {
+		if normalizeConfigKey(f.key) == key {
+			return f, true
+		}
+	}
+	return configField{}, false
+}
+
+func (l *ConfigLoader) findSection(key string) (string, any, bool) {
+	for name, target := range l.Sections {
+		if normalizeConfigKey(name) == key {
+			return name, target, true
+		}
+	}
+	return "", nil, false
+}
+
+func (l *ConfigLoader) loadMap(config *WebConfig, source string, m map[string]any, sources configSources, errs *ConfigErrors) {
+	for _, k := range sortedConfigKeys(m) {
+		v := m[k]
+		nk := normalizeConfigKey(k)
+		if name, target, ok := l.findSection(nk); ok {
+			if err := decodeConfigSection(v, target); err != nil {
+				errs.add(source, k, "%v", err)
+			} else {
+				config.SetDependentRef(name, target)
+			}
+			continue
+		}
+		switch nk {
+		case "envs":
+			loadEnvConfigs(config, source, k, v, sources, errs)
+		case "openapi", "tls", "engine":
+			sub, ok := v.(map[string]any)
+			if !ok {
+				errs.add(source, k, "should be a map")
+				continue
+			}
+			for _, subKey := range sortedConfigKeys(sub) {
+				if f, ok := findConfigField(nk + "." + normalizeConfigKey(subKey)); ok {
+					if err := assignConfigValue(f.ptr(config), sub[subKey]); err != nil {
+						errs.add(source, k+"."+subKey, "%v", err)
+					} else {
+						sources.set(f.key, source, k+"."+subKey)
+					}
+				} else {
+					errs.add(source, k+"."+subKey, "unknown key")
+				}
+			}
+		default:
+			if f, ok := findConfigField(nk); ok && !strings.Contains(f.key, ".") {
+				if err := assignConfigValue(f.ptr(config), v); err != nil {
+					errs.add(source, k, "%v", err)
+				} else {
+					sources.set(f.key, source, k)
+				}
+			} else {
+				errs.add(source, k, "unknown key")
+			}
+		}
+	}
+}
+
+func loadEnvConfigs(config *WebConfig, source string, key string, v any, sources configSources, errs *ConfigErrors) {
+	envs, ok := v.(map[string]any)
+	if !ok {
+		errs.add(source, key, "should be a map of runtime environments")
+		return
+	}
+	for _, name := range sortedConfigKeys(envs) {
+		fields, ok := envs[name].(map[string]any)
+		if !ok {
+			errs.add(source, key+"."+name, "should be a map")
+			continue
+		}
+		config.SetEnv(RuntimeEnv(name), func(e *EnvConfig) {
+			for _, fieldKey := range sortedConfigKeys(fields) {
+				if ptr, ok := envConfigFields[normalizeConfigKey(fieldKey)]; ok {
+					if err := assignConfigValue(ptr(e), fields[fieldKey]); err != nil {
+						errs.add(source, key+"."+name+"."+fieldKey, "%v", err)
+					} else {
+						sources.set("envs."+name+"."+normalizeConfigKey(fieldKey), source, key+"."+name+"."+fieldKey)
+					}
+				} else {
+					errs.add(source, key+"."+name+"."+fieldKey, "unknown key")
+				}
+			}
+		})
+	}
+}
+
+func (l *ConfigLoader) loadEnv(config *WebConfig, sources configSources, errs *ConfigErrors) {
+	lookup := l.LookupEnv
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+	for _, f := range webConfigFields {
+		key := l.EnvPrefix + f.env
+		if v, ok := lookup(key); ok {
+			if err := assignConfigValue(f.ptr(config), v); err != nil {
+				errs.add("env", key, "%v", err)
+			} else {
+				sources.set(f.key, "env", key)
+			}
+		}
+	}
+}
+
+func validateWebConfig(config *WebConfig, sources configSources, errs *ConfigErrors) {
+	if config.Port < 0 || config.Port > 65535 {
+		sources.add(errs, "port", "should be in range 0-65535")
+	}
+	if config.OpenApi.Port < 0 || config.OpenApi.Port > 65535 {
+		sources.add(errs, "openApi.port", "should be in range 0-65535")
+	}
+	if config.MaxConn < 0 {
+		sources.add(errs, "maxConn", "should not be negative")
+	}
+	if config.ShutdownTimeout < 0 {
+		sources.add(errs, "shutdownTimeout", "should not be negative")
+	}
+	if env := config.RtEnv; len(env) > 0 && !env.IsBuiltin() {
+		if _, ok := config.Envs[env]; !ok {
+			sources.add(errs, "rtEnv", "unknown runtime environment '%s', it should be registered in envs", env)
+		}
+	}
+	for name, e := range config.Envs {
+		if len(e.Base) > 0 && !e.Base.IsBuiltin() {
+			if _, ok := config.Envs[e.Base]; !ok {
+				sources.add(errs, "envs."+string(name)+".base", "unknown runtime environment '%s'", e.Base)
+			}
+		}
+	}
+	for _, s := range config.OpenApi.Schemes {
+		if s != "http" && s != "https" {
+			sources.add(errs, "openApi.schemes", "unknown scheme '%s', it should be http or https", s)
+		}
+	}
+	switch config.Engine.GinMode {
+	case "", gin.DebugMode, gin.ReleaseMode, gin.TestMode:
+	default:
+		sources.add(errs, "engine.ginMode", "unknown gin mode '%s'", config.Engine.GinMode)
+	}
+}
+
+func readConfigFile(file string) (map[string]any, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]any{}
+	switch ext := strings.ToLower(filepath.Ext(file)); ext {
+	case ".yaml", ".yml":
+		var v map[any]any
+		if err = yaml.Unmarshal(data, &v); err == nil {
+			m, err = normalizeConfigMap(v)
+		}
+	case ".json":
+		err = json.Unmarshal(data, &m)
+	case ".toml":
+		err = toml.Unmarshal(data, &m)
+	default:
+		err = fmt.Errorf("unsupported config format '%s'", ext)
+	}
+	return m, err
+}
+
+// normalizeConfigMap converts map[any]any decoded by yaml to map[string]any recursively.
+func normalizeConfigMap(m map[any]any) (map[string]any, error) {
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("key '%v' should be a string", k)
+		}
+		value, err := normalizeConfigValue(v)
+		if err != nil {
+			return nil, err
+		}
+		out[key] = value
+	}
+	return out, nil
+}
+
+func normalizeConfigValue(v any) (any, error) {
+	switch t := v.(type) {
+	case map[any]any:
+		return normalizeConfigMap(t)
+	case []any:
+		out := make([]any, len(t))
+		for i, e := range t {
+			value, err := normalizeConfigValue(e)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = value
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+func sortedConfigKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// decodeConfigSection decodes the section into target by json tags, unknown fields are errors.
+func decodeConfigSection(v any, target any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(target)
+}
+
+// assignConfigValue assigns v from config file or environment variable (always string) into ptr.
+func assignConfigValue(ptr any, v any) error {
+	switch p := ptr.(type) {
+	case *string:
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("should be a string")
+		}
+		*p = s
+	case *RuntimeEnv:
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("should be a string")
+		}
+		*p = RuntimeEnv(s)
+	case *int:
+		n, err := configValueToInt(v)
+		if err != nil {
+			return err
+		}
+		*p = int(n)
+	case *bool:
+		b, err := configValueToBool(v)
+		if err != nil {
+			return err
+		}
+		*p = b
+	case **bool:
+		b, err := configValueToBool(v)
+		if err != nil {
+			return err
+		}
+		*p = &b
+	case *time.Duration:
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("should be a duration, ex: 10s")
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("should be a duration, ex: 10s")
+		}
+		*p = d
+	case *uint16: // tls version
+		s := fmt.Sprint(v)
+		if f, ok := v.(float64); ok {
+			s = strconv.FormatFloat(f, 'f', 1, 64) // 1.0 of config files is decoded as a number
+		}
+		versions := map[string]uint16{"1.0": tls.VersionTLS10, "1.1": tls.VersionTLS11, "1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13}
+		version, ok := versions[s]
+		if !ok {
+			return fmt.Errorf("should be a tls version, 1.0, 1.1, 1.2 or 1.3")
+		}
+		*p = version
+	case *[]string:
+		switch t := v.(type) {
+		case string: // comma-separated, it's the format of environment variables
+			*p = []string{}
+			for _, s := range strings.Split(t, ",") {
+				if s = strings.TrimSpace(s); len(s) > 0 {
+					*p = append(*p, s)
+				}
+			}
+		case []any:
+			*p = make([]string, 0, len(t))
+			for _, e := range t {
+				s, ok := e.(string)
+				if !ok {
+					return fmt.Errorf("should be a list of strings")
+				}
+				*p = append(*p, s)
+			}
+		default:
+			return fmt.Errorf("should be a list of strings")
+		}
+	default:
+		return fmt.Errorf("unsupported type %T", ptr)
+	}
+	return nil
+}
+
+func configValueToInt(v any) (int64, error) {
+	switch t := v.(type) {
+	case int:
+		return int64(t), nil
+	case int64:
+		return t, nil
+	case float64:
+		if t == float64(int64(t)) {
+			return int64(t), nil
+		}
+	case string:
+		if n, err := strconv.ParseInt(strings.TrimSpace(t), 10, 64); err == nil {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("should be an integer")
+}
+
+func configValueToBool(v any) (bool, error) {
+	switch t := v.(type) {
+	case bool:
+		return t, nil
+	case string:
+		if b, err := strconv.ParseBool(strings.TrimSpace(t)); err == nil {
+			return b, nil
+		}
+	}
+	return false, fmt.Errorf("should be a boolean")
+}
@@ -5,10 +5,9 @@
 package dij_gin
 
 import (
+	"crypto/x509"
 	"encoding/json"
-	"fmt"
 	"github.com/gin-gonic/gin"
-	"log"
 	"reflect"
 )
 
@@ -28,21 +27,29 @@ func IsTypeOfWebContext(typ reflect.Type) bool {
 type InWay = string
 
 const (
-	InHeaderWay InWay = "header" // one kind of way for parameter
-	InCookieWay InWay = "cookie" // one kind of way for parameter
-	InQueryWay  InWay = "query"  // one kind of way for parameter
-	InPathWay   InWay = "path"   // one kind of way for parameter
-	InBodyWay   InWay = "body"   // one kind of way for request body
+	InHeaderWay  InWay = "header"  // one kind of way for parameter
+	InCookieWay  InWay = "cookie"  // one kind of way for parameter
+	InQueryWay   InWay = "query"   // one kind of way for parameter
+	InPathWay    InWay = "path"    // one kind of way for parameter
+	InBodyWay    InWay = "body"    // one kind of way for request body
+	InContextWay InWay = "context" // value set into gin context by a middleware, "ctx" is its alias
 )
 
 func IsCorrectInWay(way InWay) bool {
-	switch way {
-	case InHeaderWay, InQueryWay, InPathWay, InCookieWay, InBodyWay:
+	switch normalizeInWay(way) {
+	case InHeaderWay, InQueryWay, InPathWay, InCookieWay, InBodyWay, InContextWay:
 		return true
 	}
 	return false
 }
 
+func normalizeInWay(way InWay) InWay {
+	if way == "ctx" {
+		return InContextWay
+	}
+	return way
+}
+
 type WebContextSpec interface {
 	iAmAWebContext()
 
@@ -51,6 +58,15 @@ type WebContextSpec interface {
 
 type WebContext struct {
 	*gin.Context
+	logger WebLogger
+}
+
+// Logger returns the logger of framework.
+func (c *WebContext) Logger() WebLogger {
+	if c.logger == nil {
+		return NewWebLogger(gin.DefaultWriter, LogWarn)
+	}
+	return c.logger
 }
 
 func (c *WebContext) iAmAWebContext() {
@@ -76,41 +92,48 @@ func (c *WebContext) GetRequestValue(key string, instPtr any) (exists bool) {
 		return true
 	} else {
 		if err := json.Unmarshal([]byte(text), instPtr); err != nil {
-			fmt.Printf("parse key:'%s' with value:'%s' incorrectly, %v\n", key, text, err)
+			c.Logger().Log(LogWarn, "parse request value error", LogKV("key", key), LogKV("error", err))
 		}
 		return true
 	}
 }
 
+// GetRequestValueForType converts the text of key in the way into the type, it guesses the way if inWay is empty.
+// It returns false if the key doesn't exist or the text can't be converted.
 func (c *WebContext) GetRequestValueForType(key string, typ reflect.Type, inWay InWay) (data any, exists bool) {
-	var text string
+	text, exists := c.getRequestText(key, inWay)
+	if !exists {
+		return nil, false
+	}
+	instPtrVal := reflect.New(typ)
+	if err := newValueConverter(typ)(text, instPtrVal.Elem()); err != nil {
+		c.Logger().Log(LogWarn, "parse request value error", LogKV("key", key), LogKV("error", err))
+		return nil, false
+	}
+	return instPtrVal.Elem().Interface(), true
+}
+
+// getRequestText returns the text of key in the way, it guesses the way if inWay is empty.
+func (c *WebContext) getRequestText(key string, inWay InWay) (text string, exists bool) {
 	var err error
 	switch inWay {
 	case InHeaderWay:
 		text = c.GetHeader(key)
-		if exists = len(text) > 0; !exists {
-			return nil, false
-		}
+		exists = len(text) > 0
 	case InQueryWay:
-		if text, exists = c.GetQuery(key); !exists {
-			return nil, false
-		}
+		text, exists = c.GetQuery(key)
 	case InPathWay:
 		text = c.Param(key)
-		if exists = len(text) > 0; !exists {
-			return nil, false
-		}
+		exists = len(text) > 0
 	case InCookieWay:
-		if text, err = c.Cookie(key); err != nil {
-			return nil, false
-		}
+		text, err = c.Cookie(key)
+		exists = err == nil
 	case InBodyWay:
-		if text, exists = c.GetPostForm(key); !exists {
-			return nil, false
-		}
+		text, exists = c.GetPostForm(key)
 	default:
 		if len(inWay) > 0 {
-			log.Fatalln("Not support data come from this way: " + inWay)
+			c.Logger().Log(LogError, "not support data come from this way", LogKV("key", key), LogKV("in", inWay))
+			return "", false
 		}
 		// guess
 		if text, exists = c.GetQuery(key); !exists {
@@ -118,30 +141,37 @@ func (c *WebContext) GetRequestValueForType(key string, typ reflect.Type, inWay
 				if text = c.Param(key); len(text) == 0 {
 					if text = c.GetHeader(key); len(text) == 0 {
 						if text, err = c.Cookie(key); err != nil {
-							return nil, false
+							return "", false
 						}
 					}
 				}
 			}
 		}
+		exists = true
 	}
-
-	switch typ.Kind() {
-	case reflect.String:
-		return text, true
-	default:
-		instPtrVal := reflect.New(typ)
-		if err := json.Unmarshal([]byte(text), instPtrVal.Interface()); err != nil {
-			fmt.Printf("parse key:'%s' with value:'%s' incorrectly, %v\n", key, text, err)
-		}
-		return instPtrVal.Elem().Interface(), true
-	}
+	return
 }
 
 func (c *WebContext) GetRequestHeader(key string) string {
 	return c.Request.Header.Get(key)
 }
 
+// ClientCertificate returns the client certificate which is verified by mutual TLS, nil if it doesn't exist.
+func (c *WebContext) ClientCertificate() *x509.Certificate {
+	if chains := c.VerifiedClientChains(); len(chains) > 0 && len(chains[0]) > 0 {
+		return chains[0][0]
+	}
+	return nil
+}
+
+// VerifiedClientChains returns the verified chains of client certificate, nil if the connection isn't mutual TLS.
+func (c *WebContext) VerifiedClientChains() [][]*x509.Certificate {
+	if c.Request == nil || c.Request.TLS == nil {
+		return nil
+	}
+	return c.Request.TLS.VerifiedChains
+}
+
 var WebCtxType reflect.Type
 
 func init() {
@@ -26,6 +26,7 @@ type WebControllerSpec interface {
 	iAmAWebController()
 
 	// SetupRouter only be implemented for dynamic routing in runtime.
+	// The others are the controller instance, StructTagAttrs of its http tag and *SetupErrors for reporting misconfigurations.
 	SetupRouter(router WebRouter, others ...any)
 }
 
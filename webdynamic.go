@@ -0,0 +1,303 @@
+// Copyright 2022 Yuchi Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package dij_gin
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/letscool/dij-gin/spec"
+	"github.com/letscool/lc-go/dij"
+	"github.com/letscool/lc-go/lg"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const RefKeyForWebDynamicRoutes = "_.webserver.dynamic.routes"
+
+var openapiMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// DynamicRoutes attaches and detaches controllers while the web server is running, ex: plugins of tenants.
+//
+// Gin can't add routes once serving, so every attached controller is served by its own gin engine, and the list
+// of engines is swapped atomically for every change. In-flight requests finish with the old list.
+// Dynamic routes are matched only if no static route matches, a dynamic route with the same method and path
+// as a static or another dynamic route is a setup error. NoRoute and NoMethod handlers of attached controllers are ignored.
+//
+// The OpenAPI document in RefKeyForWebSpecRecord has static routes only and isn't changed after PrepareGin,
+// use Openapi() to read the document with dynamic routes, it's swapped atomically for every change.
+type DynamicRoutes struct {
+	refPtr  dij.DependencyReferencePtr // ref of web server
+	mu      sync.Mutex                 // serializes Attach and Detach
+	entries atomic.Pointer[[]*dynamicEntry]
+	doc     atomic.Pointer[spec.Openapi] // the document with static and dynamic routes, nil if OpenAPI is disabled.
+	base    *spec.Openapi                // the document with static routes only, it's in RefKeyForWebSpecRecord.
+}
+
+// dynamicEntry is an attached controller, it isn't changed after attaching.
+type dynamicEntry struct {
+	name       string
+	engine     *gin.Engine
+	routes     []RouteInfo
+	operations []dynamicOperation
+}
+
+type dynamicOperation struct {
+	path      string
+	method    string
+	operation *spec.Operation
+}
+
+// GetDynamicRoutes retrieves the dynamic routes created by PrepareGin.
+func GetDynamicRoutes(refPtr dij.DependencyReferencePtr) *DynamicRoutes {
+	if v, ok := refPtr.Get(RefKeyForWebDynamicRoutes); ok {
+		return v.(*DynamicRoutes)
+	}
+	return nil
+}
+
+func newDynamicRoutes(refPtr dij.DependencyReferencePtr) *DynamicRoutes {
+	return &DynamicRoutes{refPtr: refPtr}
+}
+
+// ready is called after static routes are set up, the document of static routes is kept for detaching.
+func (d *DynamicRoutes) ready() {
+	if v, ok := d.refPtr.Get(RefKeyForWebSpecRecord); ok {
+		d.base = v.(*spec.Openapi)
+		d.doc.Store(d.base)
+	}
+}
+
+// cloneOpenapi copies the document, operations are shared because they are never changed after adding.
+func cloneOpenapi(doc *spec.Openapi) *spec.Openapi {
+	clone := *doc
+	clone.Paths = spec.Paths{}
+	for k, v := range doc.Paths {
+		clone.Paths[k] = v
+	}
+	return &clone
+}
+
+func (d *DynamicRoutes) loadEntries() []*dynamicEntry {
+	if entries := d.entries.Load(); entries != nil {
+		return *entries
+	}
+	return nil
+}
+
+// Attach builds the controller with dependency injection and serves its routes, the name should be unique.
+// The controllerTypeOrInst is a struct type which embeds WebController, or a pointer of its instance.
+func (d *DynamicRoutes) Attach(name string, controllerTypeOrInst any) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	entries := d.loadEntries()
+	for _, e := range entries {
+		if e.name == name {
+			return fmt.Errorf("dynamic controller(%s) has been attached", name)
+		}
+	}
+	var doc *spec.Openapi
+	if current := d.doc.Load(); current != nil {
+		// a published document isn't changed, the operations of the controller are added into a copy.
+		doc = cloneOpenapi(current)
+	}
+	entry, err := d.build(name, controllerTypeOrInst, doc)
+	if err != nil {
+		return err
+	}
+	d.publish(append(entries[:len(entries):len(entries)], entry), doc)
+	return nil
+}
+
+// Detach removes the routes of the controller attached with the name.
+func (d *DynamicRoutes) Detach(name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	entries := d.loadEntries()
+	remaining := lg.Filter(entries, func(e *dynamicEntry) bool {
+		return e.name != name
+	})
+	if len(remaining) == len(entries) {
+		return fmt.Errorf("dynamic controller(%s) doesn't exist", name)
+	}
+	var doc *spec.Openapi
+	if d.base != nil {
+		doc = cloneOpenapi(d.base)
+		for _, e := range remaining {
+			for _, op := range e.operations {
+				doc.AddPathOperation(op.path, op.method, *op.operation)
+			}
+		}
+	}
+	d.publish(remaining, doc)
+	return nil
+}
+
+func (d *DynamicRoutes) publish(entries []*dynamicEntry, doc *spec.Openapi) {
+	d.entries.Store(&entries)
+	if doc != nil {
+		d.doc.Store(doc)
+	}
+}
+
+// Names returns names of attached controllers in attaching order.
+func (d *DynamicRoutes) Names() []string {
+	return lg.Map(d.loadEntries(), func(e *dynamicEntry) string {
+		return e.name
+	})
+}
+
+// Routes returns the routes of attached controllers.
+func (d *DynamicRoutes) Routes() []RouteInfo {
+	routes := make([]RouteInfo, 0)
+	for _, e := range d.loadEntries() {
+		routes = append(routes, e.routes...)
+	}
+	return routes
+}
+
+// Openapi returns a copy of the document with static and dynamic routes, it returns nil if OpenAPI is disabled.
+func (d *DynamicRoutes) Openapi() *spec.Openapi {
+	current := d.doc.Load()
+	if current == nil {
+		return nil
+	}
+	doc := *current
+	return &doc
+}
+
+// build creates the instance and its engine, operations of the controller are added into doc.
+// The instance is built with a copy of ref, so the ref of web server isn't changed by dependency injection.
+func (d *DynamicRoutes) build(name string, controllerTypeOrInst any, doc *spec.Openapi) (entry *dynamicEntry, err error) {
+	var instType reflect.Type
+	if typ, ok := controllerTypeOrInst.(reflect.Type); ok {
+		instType = typ
+	} else if typ = reflect.TypeOf(controllerTypeOrInst); typ != nil && typ.Kind() == reflect.Pointer {
+		instType = typ.Elem()
+	}
+	if instType == nil || instType.Kind() != reflect.Struct || !IsTypeOfWebController(instType) {
+		return nil, fmt.Errorf("dynamic controller(%s) should be a web controller, but got %T", name, controllerTypeOrInst)
+	}
+	config := (*d.refPtr)[RefKeyForWebConfig].(*WebConfig)
+	entry = &dynamicEntry{name: name, engine: gin.New()}
+	if err := applyClientIPSettings(entry.engine, config.Engine); err != nil {
+		return nil, err
+	}
+
+	ref := dij.DependencyReference{}
+	for k, v := range *d.refPtr {
+		if k != dij.StackKey && k != dij.StackDeepKey {
+			ref[k] = v
+		}
+	}
+	table := &RouteTable{}
+	ref[RefKeyForWebRouteTable] = table
+	ref[RefKeyForWebNoRoute] = &NoRouteChain{}
+	registry := GetMiddlewareRegistry(d.refPtr).clone()
+	ref[RefKeyForWebMiddlewares] = registry
+	if doc != nil {
+		ref[RefKeyForWebSpecRecord] = doc
+	}
+	ref[RefKeyForWebDijRef] = &ref
+	var instPtr any
+	if _, ok := controllerTypeOrInst.(reflect.Type); ok {
+		instPtr, err = dij.CreateInstance(instType, &ref, "^")
+	} else {
+		instPtr, err = dij.BuildAnyInstance(controllerTypeOrInst, &ref, "^")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("build dynamic controller(%s) error: %w", name, err)
+	}
+
+	var before spec.Paths
+	if doc != nil {
+		before = cloneOpenapi(doc).Paths
+	}
+	var errs SetupErrors
+	func() {
+		defer func() {
+			// gin panics for conflicting routes
+			if r := recover(); r != nil {
+				errs.Add(&SetupError{Controller: instType, Reason: fmt.Sprint(r)})
+			}
+		}()
+		registry.registerMiddlewares(instPtr, instType, &ref, &errs)
+		setupRouterHandlers(instPtr, instType, entry.engine, routerScope{mounted: map[any]string{}}, &ref, &errs)
+	}()
+	entry.routes = table.Routes
+	for i := range entry.routes {
+		r := &entry.routes[i]
+		r.Dynamic = name
+		if static := GetRouteTable(d.refPtr); static != nil {
+			if _, exists := static.Find(r.Method, r.Path); exists {
+				errs.Add(&SetupError{Controller: instType, Method: r.Handler, Reason: fmt.Sprintf("route(%s %s) conflicts with a static route", r.Method, r.Path)})
+			}
+		}
+		for _, e := range d.loadEntries() {
+			for _, other := range e.routes {
+				if other.Method == r.Method && other.Path == r.Path {
+					errs.Add(&SetupError{Controller: instType, Method: r.Handler, Reason: fmt.Sprintf("route(%s %s) conflicts with dynamic controller(%s)", r.Method, r.Path, e.name)})
+				}
+			}
+		}
+	}
+	if err := errs.Err(); err != nil {
+		return nil, err
+	}
+
+	if doc != nil {
+		for path, p := range doc.Paths {
+			for _, method := range openapiMethods {
+				if op := p.Operation(method); op != nil && (before[path].Operation(method) != op) {
+					entry.operations = append(entry.operations, dynamicOperation{path, method, op})
+				}
+			}
+		}
+	}
+	return entry, nil
+}
+
+// fallback serves the request by the engine of the dynamic controller whose route matches.
+func (d *DynamicRoutes) fallback(c *gin.Context) {
+	for _, e := range d.loadEntries() {
+		if e.match(c.Request.Method, c.Request.URL.Path) {
+			e.engine.ServeHTTP(c.Writer, c.Request)
+			c.Abort()
+			return
+		}
+	}
+}
+
+func (e *dynamicEntry) match(method, path string) bool {
+	for _, r := range e.routes {
+		if r.Method == method && matchRoutePath(r.Path, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchRoutePath matches the path with a route path in gin style, ex: /user/:id or /files/*filepath.
+func matchRoutePath(routePath, path string) bool {
+	routeSegments := strings.Split(routePath, "/")
+	segments := strings.Split(path, "/")
+	for i, rs := range routeSegments {
+		if strings.HasPrefix(rs, "*") {
+			return true
+		}
+		if i >= len(segments) {
+			return false
+		}
+		if strings.HasPrefix(rs, ":") {
+			if len(segments[i]) == 0 {
+				return false
+			}
+		} else if rs != segments[i] {
+			return false
+		}
+	}
+	return len(routeSegments) == len(segments)
+}
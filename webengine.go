@@ -0,0 +1,94 @@
+// Copyright 2022 Yuchi Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package dij_gin
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/letscool/lc-go/lg"
+)
+
+// EngineConfig presents the settings of gin engine created by PrepareGin.
+// The zero value behaves like gin.Default(), except gin mode is derived from runtime environment.
+type EngineConfig struct {
+	GinMode         string            // gin.DebugMode, gin.ReleaseMode or gin.TestMode. Default is derived from RtEnv.
+	BaseMiddlewares []gin.HandlerFunc // nil means gin's logger and recovery, an empty slice means none.
+	TrustedProxies  []string          // IPs or CIDRs, nil means trusting all proxies (gin's default), an empty slice means trusting none.
+	TrustedPlatform string            // ex: gin.PlatformCloudflare, or a custom header which contains client ip.
+	RemoteIPHeaders []string          // nil means gin's default, "X-Forwarded-For" and "X-Real-IP".
+}
+
+func (e *EngineConfig) SetGinMode(mode string) *EngineConfig {
+	e.GinMode = mode
+	return e
+}
+
+// SetBaseMiddlewares replaces gin's logger and recovery, no argument means no base middleware.
+func (e *EngineConfig) SetBaseMiddlewares(middlewares ...gin.HandlerFunc) *EngineConfig {
+	e.BaseMiddlewares = append([]gin.HandlerFunc{}, middlewares...)
+	return e
+}
+
+// UseRecoveryOnly removes gin's logger, it is useful if access logs are handled by a middleware or a proxy.
+func (e *EngineConfig) UseRecoveryOnly() *EngineConfig {
+	return e.SetBaseMiddlewares(gin.Recovery())
+}
+
+// SetTrustedProxies sets IPs or CIDRs of trusted proxies, no argument means trusting none.
+func (e *EngineConfig) SetTrustedProxies(proxies ...string) *EngineConfig {
+	e.TrustedProxies = append([]string{}, proxies...)
+	return e
+}
+
+func (e *EngineConfig) SetTrustedPlatform(platform string) *EngineConfig {
+	e.TrustedPlatform = platform
+	return e
+}
+
+func (e *EngineConfig) SetRemoteIPHeaders(headers ...string) *EngineConfig {
+	e.RemoteIPHeaders = append([]string{}, headers...)
+	return e
+}
+
+// GinMode returns gin mode of the runtime environment, release in prod, test in test and debug in others.
+func (r RuntimeEnv) GinMode() string {
+	switch r {
+	case RtProd:
+		return gin.ReleaseMode
+	case RtTest:
+		return gin.TestMode
+	}
+	return gin.DebugMode
+}
+
+// newGinEngine creates gin engine by config, gin mode is global, so it is applied before creating engine.
+func newGinEngine(config *WebConfig) (*gin.Engine, error) {
+	e := config.Engine
+	gin.SetMode(lg.Ife(e.GinMode == "", config.BaseEnv().GinMode(), e.GinMode))
+	engine := gin.New()
+	if e.BaseMiddlewares == nil {
+		engine.Use(gin.Logger(), gin.Recovery())
+	} else if len(e.BaseMiddlewares) > 0 {
+		engine.Use(e.BaseMiddlewares...)
+	}
+	if err := applyClientIPSettings(engine, e); err != nil {
+		return nil, err
+	}
+	return engine, nil
+}
+
+// applyClientIPSettings applies the settings of client ip, aka. trusted proxies, platform and remote ip headers.
+func applyClientIPSettings(engine *gin.Engine, e EngineConfig) error {
+	if e.TrustedProxies != nil {
+		if err := engine.SetTrustedProxies(e.TrustedProxies); err != nil {
+			return fmt.Errorf("incorrect trusted proxies: %w", err)
+		}
+	}
+	engine.TrustedPlatform = e.TrustedPlatform
+	if e.RemoteIPHeaders != nil {
+		engine.RemoteIPHeaders = e.RemoteIPHeaders
+	}
+	return nil
+}
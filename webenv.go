@@ -0,0 +1,192 @@
+// Copyright 2022 Yuchi Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package dij_gin
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EnvConfig presents a runtime environment registered on WebConfig, and the overlay applied on WebConfig in the environment.
+// A user-defined environment behaves like its base environment, ex: staging based on prod is silent and runs gin in release mode,
+// and it is also matched by env expressions of the base environment.
+type EnvConfig struct {
+	Base           RuntimeEnv     // environment which this one behaves like, default is dev. It is ignored by builtin environments.
+	Address        string         // overrides WebConfig.Address if not empty
+	Port           int            // overrides WebConfig.Port if positive
+	BasePath       string         // overrides WebConfig.BasePath if not empty
+	OpenApiEnabled *bool          // overrides WebConfig.OpenApi.Enabled if not nil
+	DependentRefs  map[string]any // merged into WebConfig.DependentRefs
+}
+
+func (e *EnvConfig) SetBase(base RuntimeEnv) *EnvConfig {
+	e.Base = base
+	return e
+}
+
+func (e *EnvConfig) SetAddress(addr string) *EnvConfig {
+	e.Address = addr
+	return e
+}
+
+func (e *EnvConfig) SetPort(port int) *EnvConfig {
+	e.Port = port
+	return e
+}
+
+func (e *EnvConfig) SetBasePath(path string) *EnvConfig {
+	e.BasePath = path
+	return e
+}
+
+func (e *EnvConfig) SetOpenApiEnabled(en bool) *EnvConfig {
+	e.OpenApiEnabled = &en
+	return e
+}
+
+func (e *EnvConfig) SetDependentRef(key string, ref any) *EnvConfig {
+	if e.DependentRefs == nil {
+		e.DependentRefs = map[string]any{}
+	}
+	e.DependentRefs[key] = ref
+	return e
+}
+
+// IsBuiltin returns true for prod, dev, debug and test.
+func (r RuntimeEnv) IsBuiltin() bool {
+	switch r {
+	case RtProd, RtDev, RtDebug, RtTest:
+		return true
+	}
+	return false
+}
+
+// SetEnv registers a user-defined environment or sets the overlay of a builtin environment.
+//
+//	config.SetEnv("staging", func(e *EnvConfig) {
+//	  e.SetBase(RtProd).SetPort(8080).SetOpenApiEnabled(true)
+//	})
+func (c *WebConfig) SetEnv(env RuntimeEnv, f func(e *EnvConfig)) *WebConfig {
+	if c.Envs == nil {
+		c.Envs = map[RuntimeEnv]*EnvConfig{}
+	}
+	e, ok := c.Envs[env]
+	if !ok {
+		e = &EnvConfig{}
+		c.Envs[env] = e
+	}
+	f(e)
+	return c
+}
+
+// EnvChain returns the runtime environment followed by its base environments, ex: [canary staging prod].
+func (c *WebConfig) EnvChain() []RuntimeEnv {
+	env := c.RtEnv
+	if env == "" {
+		env = RtDev
+	}
+	chain := []RuntimeEnv{env}
+	for !env.IsBuiltin() {
+		e, ok := c.Envs[env]
+		if !ok {
+			break
+		}
+		env = e.Base
+		if env == "" {
+			env = RtDev
+		}
+		for _, v := range chain {
+			if v == env {
+				return chain
+			}
+		}
+		chain = append(chain, env)
+	}
+	return chain
+}
+
+// BaseEnv returns the builtin environment which the runtime environment behaves like, it is dev for an unregistered environment.
+func (c *WebConfig) BaseEnv() RuntimeEnv {
+	chain := c.EnvChain()
+	if env := chain[len(chain)-1]; env.IsBuiltin() {
+		return env
+	}
+	return RtDev
+}
+
+// IsInEnv checks the runtime environment with the env expression, see RuntimeEnv.IsInOnlyEnv for the format.
+// The base environments are also checked, ex: "!prod" excludes staging which is based on prod.
+func (c *WebConfig) IsInEnv(onlyEnv string) bool {
+	return matchEnvExpr(onlyEnv, c.EnvChain())
+}
+
+// ValidateEnvExpr returns an error if any environment in the expression is unknown, it avoids hiding routes by typo.
+func (c *WebConfig) ValidateEnvExpr(onlyEnv string) error {
+	for _, token := range strings.Split(onlyEnv, "&") {
+		name := RuntimeEnv(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(token), "!")))
+		if len(name) == 0 || name.IsBuiltin() || name == c.RtEnv {
+			continue
+		}
+		if _, ok := c.Envs[name]; !ok {
+			return fmt.Errorf("unknown runtime environment '%s' in env expression '%s'", name, onlyEnv)
+		}
+	}
+	return nil
+}
+
+// applyEnvOverlays applies overlays from the base environment to the runtime environment.
+func (c *WebConfig) applyEnvOverlays() {
+	chain := c.EnvChain()
+	for i := len(chain) - 1; i >= 0; i-- {
+		e, ok := c.Envs[chain[i]]
+		if !ok {
+			continue
+		}
+		if len(e.Address) > 0 {
+			c.Address = e.Address
+		}
+		if e.Port > 0 {
+			c.Port = e.Port
+		}
+		if len(e.BasePath) > 0 {
+			c.BasePath = e.BasePath
+		}
+		if e.OpenApiEnabled != nil {
+			c.OpenApi.Enabled = *e.OpenApiEnabled
+		}
+		for k, v := range e.DependentRefs {
+			c.SetDependentRef(k, v)
+		}
+	}
+}
+
+// matchEnvExpr returns true if any environment in chain is included and none of them is excluded.
+func matchEnvExpr(onlyEnv string, chain []RuntimeEnv) bool {
+	included, hasInclusion := false, false
+	for _, token := range strings.Split(onlyEnv, "&") {
+		token = strings.TrimSpace(token)
+		negative := strings.HasPrefix(token, "!")
+		name := RuntimeEnv(strings.TrimSpace(strings.TrimPrefix(token, "!")))
+		if len(name) == 0 {
+			continue
+		}
+		inChain := false
+		for _, env := range chain {
+			if env == name {
+				inChain = true
+				break
+			}
+		}
+		if negative {
+			if inChain {
+				return false
+			}
+		} else {
+			hasInclusion = true
+			included = included || inChain
+		}
+	}
+	return !hasInclusion || included
+}
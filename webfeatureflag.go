@@ -0,0 +1,118 @@
+// Copyright 2022 Yuchi Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package dij_gin
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/letscool/lc-go/dij"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	RefKeyForWebFeatureFlags = "_.webserver.feature.flags"
+	OpenApiFeatureFlags      = "x-feature-flags"
+)
+
+// FeatureFlagProvider decides whether a feature flag is on, it is checked for every request of the routes with `flag=name` attribute.
+// Set it into DependentRefs by WebConfig.SetFeatureFlagProvider.
+type FeatureFlagProvider interface {
+	IsEnabled(c *gin.Context, flag string) bool
+}
+
+// MutableFeatureFlagProvider is a provider whose flags can be listed and flipped at runtime, ex: by libs.FeatureFlagController.
+type MutableFeatureFlagProvider interface {
+	FeatureFlagProvider
+	SetFlag(flag string, enabled bool)
+	Flags() map[string]bool
+}
+
+// MemoryFeatureFlags is an in-memory provider, a flag which is never set is off.
+type MemoryFeatureFlags struct {
+	mu    sync.RWMutex
+	flags map[string]bool
+}
+
+func NewMemoryFeatureFlags(flags map[string]bool) *MemoryFeatureFlags {
+	m := &MemoryFeatureFlags{flags: map[string]bool{}}
+	for k, v := range flags {
+		m.flags[k] = v
+	}
+	return m
+}
+
+func (m *MemoryFeatureFlags) IsEnabled(_ *gin.Context, flag string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.flags[flag]
+}
+
+func (m *MemoryFeatureFlags) SetFlag(flag string, enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.flags == nil {
+		m.flags = map[string]bool{}
+	}
+	m.flags[flag] = enabled
+}
+
+// Flags returns a copy of all flags.
+func (m *MemoryFeatureFlags) Flags() map[string]bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	flags := make(map[string]bool, len(m.flags))
+	for k, v := range m.flags {
+		flags[k] = v
+	}
+	return flags
+}
+
+// GetFeatureFlagProvider retrieves the provider from DependentRefs.
+func GetFeatureFlagProvider(refPtr dij.DependencyReferencePtr) (FeatureFlagProvider, bool) {
+	if v, ok := refPtr.Get(RefKeyForWebFeatureFlags); ok {
+		provider, ok := v.(FeatureFlagProvider)
+		return provider, ok
+	}
+	return nil, false
+}
+
+// parseFeatureFlags parses the value of flag attribute, flags are separated by '&' and all of them should be on.
+func parseFeatureFlags(val string) []string {
+	flags := make([]string, 0)
+	for _, flag := range strings.Split(val, "&") {
+		if flag = strings.TrimSpace(flag); len(flag) > 0 {
+			flags = append(flags, flag)
+		}
+	}
+	return flags
+}
+
+// uniqueFeatureFlags removes duplicated flags and sorts them.
+func uniqueFeatureFlags(flags []string) []string {
+	m := map[string]struct{}{}
+	unique := make([]string, 0, len(flags))
+	for _, flag := range flags {
+		if _, exists := m[flag]; !exists {
+			m[flag] = struct{}{}
+			unique = append(unique, flag)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
+
+// featureFlagHandler aborts the request with status if any flag is off, the response looks like the route doesn't exist.
+func featureFlagHandler(provider FeatureFlagProvider, flags []string, status int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, flag := range flags {
+			if !provider.IsEnabled(c, flag) {
+				c.AbortWithStatus(status)
+				return
+			}
+		}
+		c.Next()
+	}
+}
@@ -11,7 +11,6 @@ import (
 	"github.com/letscool/dij-gin/spec"
 	"github.com/letscool/lc-go/dij"
 	. "github.com/letscool/lc-go/lg"
-	"log"
 	"net/http"
 	"reflect"
 	"regexp"
@@ -32,6 +31,17 @@ func init() {
 type HandlerWrapper struct {
 	Spec    HandlerSpec
 	Handler gin.HandlerFunc
+	// withArgs creates the handler of a parameterized middleware, args are parsed only if parse is true.
+	withArgs func(args string, parse bool) (gin.HandlerFunc, error)
+}
+
+// HandlerWithArgs returns the handler of a parameterized middleware, ex: args is "5/s" for `middleware=ratelimit(5/s)`.
+// The args are parsed into the options field of the middleware handler and validated.
+func (w *HandlerWrapper) HandlerWithArgs(args string) (gin.HandlerFunc, error) {
+	if w.withArgs == nil {
+		return nil, fmt.Errorf("middleware '%s' doesn't accept arguments", w.ReqPath())
+	}
+	return w.withArgs(args, true)
 }
 
 func (w *HandlerWrapper) ReqMethod() string {
@@ -64,6 +74,23 @@ func (w *HandlerWrapper) ConcatOpenapiPath(basePath string) (fullPath string, pa
 	return
 }
 
+// InWayOf resolves where the value of the field comes from.
+// The path way is used if the name appears in path, or the way is set by "in" attribute.
+// Otherwise, body way is used for post, put and patch methods, and query way for others.
+func (w *HandlerWrapper) InWayOf(def BaseParamField, pathParamNames []string) InWay {
+	if Contains(pathParamNames, def.PreferredName) {
+		return InPathWay
+	}
+	if attr, b := def.Attrs.FirstAttrsWithKey("in"); b {
+		return normalizeInWay(attr.Val)
+	}
+	switch w.ReqMethod() {
+	case "post", "put", "patch":
+		return InBodyWay
+	}
+	return InQueryWay
+}
+
 type HandlerSpec struct {
 	Purpose         HandlerWrapperPurpose
 	MethodType      reflect.Method
@@ -73,15 +100,37 @@ type HandlerSpec struct {
 	InFields        []BaseParamField
 	MiddlewareNames []string
 	OutFields       []BaseParamField
-	CtxAttrs        StructTagAttrs // tag attr come from the base field in InFields
-	Description     string         // description comes from the base field in InFields
-	Security        string         // security comes from the tag of base field
+	CtxAttrs        StructTagAttrs          // tag attr come from the base field in InFields
+	Description     string                  // description comes from the base field in InFields
+	Security        string                  // security comes from the tag of base field
+	Concurrency     int                     // max concurrent requests, comes from the tag of base field, zero means unlimited.
+	Flags           []string                // feature flags which should be all on, comes from the tag of base field.
+	Options         *BaseParamField         // options field of a middleware handler, nil if the middleware doesn't accept arguments.
+	Provides        map[string]reflect.Type // types of values which a middleware handler sets into gin context by key.
+}
+
+// inWayOf resolves the way of field like HandlerWrapper.InWayOf, but only with path params of the handler.
+func (s *HandlerSpec) inWayOf(def BaseParamField) InWay {
+	w := HandlerWrapper{Spec: *s}
+	_, pathParamNames := w.ConcatOpenapiPath("")
+	return w.InWayOf(def, pathParamNames)
 }
 
 func (s *HandlerSpec) UpperMethod() string {
 	return strings.ToUpper(s.Method)
 }
 
+// newSetupError creates an error about this handler.
+func (s *HandlerSpec) newSetupError(field string, format string, args ...any) *SetupError {
+	var controller reflect.Type
+	if s.MethodType.Type != nil && s.MethodType.Type.NumIn() > 0 {
+		controller = s.MethodType.Type.In(0)
+	}
+	err := newSetupError(controller, field, format, args...)
+	err.Method = s.MethodType.Name
+	return err
+}
+
 type HandlerWrapperPurpose int
 
 const (
@@ -143,6 +192,9 @@ func (c *BaseParamField) SupportedMediaTypesForRequest() []spec.MediaTypeSupport
 }
 
 func (c *BaseParamField) PreferredMediaTypeTitleForResponse() spec.MediaTypeTitle {
+	if _, _, ok := templateOfField(*c); ok {
+		return spec.HtmlPage
+	}
 	for _, v := range c.Attrs.AttrsWithValOnly() {
 		if support, ok := spec.GetSupportedMediaType(v.Val); ok && support.Resp {
 			return support.Title
@@ -172,8 +224,25 @@ func GetPreferredResponseFormat(typ reflect.Type) spec.MediaTypeTitle {
 
 type WebError struct {
 	error
+	Message string       `json:"message"`
+	Code    string       `json:"code"`
+	Details []ParamError `json:"details,omitempty"` // parameters which fail to bind or validate
+}
+
+// ParamError is the detail of a parameter which fails to bind or validate.
+type ParamError struct {
+	Name    string `json:"name"`
+	In      InWay  `json:"in,omitempty"`
+	Value   string `json:"value,omitempty"` // raw value of request
+	Type    string `json:"type"`            // expected type
 	Message string `json:"message"`
-	Code    string `json:"code"`
+}
+
+func (e ParamError) Error() string {
+	if len(e.In) > 0 {
+		return fmt.Sprintf("parameter '%s' in %s: %s", e.Name, e.In, e.Message)
+	}
+	return fmt.Sprintf("parameter '%s': %s", e.Name, e.Message)
 }
 
 func ToWebError(err error, code string) WebError {
@@ -184,139 +253,81 @@ func ToWebError(err error, code string) WebError {
 	}
 }
 
-// GenerateHandlerWrappers generates handler for the instance
-// TODO: consider to cache result for same instance.
-func GenerateHandlerWrappers(instPtr any, purpose HandlerWrapperPurpose, refPtr dij.DependencyReferencePtr) []HandlerWrapper {
+// GenerateHandlerWrappers generates handler for the instance, all misconfigurations are returned as SetupErrors.
+// Handlers of a type are analyzed and their binding plans are compiled once, instances of the same type share them.
+func GenerateHandlerWrappers(instPtr any, purpose HandlerWrapperPurpose, refPtr dij.DependencyReferencePtr) ([]HandlerWrapper, error) {
+	var errs SetupErrors
 	wrappers := make([]HandlerWrapper, 0)
-	instPtrType := reflect.TypeOf(instPtr)
-	handleMethodRegex := purpose.Regexp()
-	rtEnv := ((*refPtr)[RefKeyForWebConfig].(*WebConfig)).RtEnv
-	// TODO: how to deal routing for static pages
-	for i := 0; i < instPtrType.NumMethod(); i++ {
-		method := instPtrType.Method(i)
-		if method.IsExported() {
-			methodType := method.Type
-			if methodType.NumIn() == 2 && methodType.NumOut() <= 1 {
-				baseParamType := methodType.In(1)
-				if IsTypeOfWebContext(baseParamType) && baseParamType.Kind() == reflect.Struct {
-					hdlSpec := HandlerSpec{
-						Purpose:       purpose,
-						BaseParamType: baseParamType,
-						MethodType:    method,
-					}
-					// Only fit function with one parameter and the parameter extends WebContext.
-					methodName := method.Name
-					lowerMethodName := strings.ToLower(methodName)
-					hdlSpec.Method = string(handleMethodRegex.Find([]byte(lowerMethodName)))
-					hdlSpec.Path = lowerMethodName[len(hdlSpec.Method):]
-
-					if purpose == HandlerForReq {
-						switch methodType.NumOut() {
-						case 0: // ignore
-						case 1:
-							analyzeOutBaseParam(methodType.Out(0), purpose, &hdlSpec)
-							// TODO: below handler should deal response
-						default:
-							log.Fatalf("Handler function(%s) can not return more than one value.(%d)", methodType.Name(), methodType.NumOut())
-						}
-					}
-
-					if baseParamType != WebCtxType {
-						// this part extends WebContext, so it also should process tag information
-						fmt.Printf("[*%v]'s method %d: func %v(%s)\n", instPtrType.Elem().Name(), i, methodName, baseParamType)
-						//fmt.Printf("\t%s\n", baseParamType.Name())
-						analyzeInBaseParam(baseParamType, purpose, &hdlSpec)
-						if envOnly, ok := hdlSpec.CtxAttrs.FirstAttrsWithKey("env"); ok {
-							if !rtEnv.IsInOnlyEnv(envOnly.Val) {
-								continue
-							}
-						}
-
-						valid := (*refPtr)[RefKeyForWebValidator].(*validator.Validate)
-
-						wrappers = append(wrappers, HandlerWrapper{
-							hdlSpec,
-							func(c *gin.Context) {
-								baseParamInstPtrVal := reflect.New(baseParamType)
-								baseParamInstVal := baseParamInstPtrVal.Elem()
-								ctx := WebContext{c}
-								for _, def := range hdlSpec.InFields {
-									fieldSpec := def.FieldSpec
-									fieldSpecType := fieldSpec.Type
-									field := baseParamInstVal.Field(def.Index)
-									if fieldSpec.Anonymous && fieldSpecType == WebCtxType {
-										field.Set(reflect.ValueOf(ctx))
-									} else {
-										var val any = nil
-										ok := false
-										if fieldSpecType.Kind() == reflect.Struct {
-											value := reflect.New(fieldSpecType)
-											if err := c.ShouldBind(value.Interface()); err == nil {
-												val = value.Elem().Interface()
-												ok = true
-											} else {
-												log.Printf("bind type(%v) with json error: %v\n", fieldSpecType, err)
-											}
-										} else if fieldSpecType.Kind() == reflect.Pointer && fieldSpecType.Elem().Kind() == reflect.Struct {
-											value := reflect.New(fieldSpecType.Elem())
-											if err := c.ShouldBind(value.Interface()); err == nil {
-												val = value.Interface()
-												ok = true
-											} else {
-												log.Printf("bind type(%v) with json error: %v\n", fieldSpecType, err)
-											}
-										} else {
-											in, b := def.Attrs.FirstAttrsWithKey("in")
-											val, ok = ctx.GetRequestValueForType(def.PreferredName, fieldSpecType, Ife(b, in.Val, ""))
-										}
-										if ok {
-											fieldName := fieldSpec.Name
-											if reflect.TypeOf(val) == fieldSpecType {
-												if len(fieldName) == 0 || fieldName[0] == '_' {
-													// ignore
-												} else if fieldName[0] >= 'A' && fieldName[0] <= 'Z' {
-													field.Set(reflect.ValueOf(val))
-												} else {
-													dij.SetUnexportedField(field, val)
-												}
-											}
-										}
-									}
-								}
-								//fmt.Printf("I'm in")
-								if err := valid.Struct(baseParamInstPtrVal.Interface()); err != nil {
-									webErr := ToWebError(err, strconv.Itoa(http.StatusBadRequest))
-									c.JSON(http.StatusBadRequest, webErr)
-								} else {
-									outData := reflect.ValueOf(instPtr).MethodByName(methodName).Call([]reflect.Value{baseParamInstVal})
-									generateOutputData(c, methodName, outData, hdlSpec)
-								}
-							},
-						})
+	instPtrVal := reflect.ValueOf(instPtr)
+	config := (*refPtr)[RefKeyForWebConfig].(*WebConfig)
+	logger := (*refPtr)[RefKeyForWebLogger].(WebLogger)
+	valid := (*refPtr)[RefKeyForWebValidator].(*validator.Validate)
+	templates, _ := GetTemplateRenderer(refPtr)
+	for _, h := range analyzeHandlers(instPtrVal.Type(), purpose) {
+		hdlSpec := h.spec
+		plan := h.plan
+		methodName := hdlSpec.MethodType.Name
+		methodVal := instPtrVal.Method(h.methodIndex)
+		numOfErrs := len(errs)
+		errs = append(errs, h.errs...)
+		checkTemplates(&hdlSpec, refPtr, &errs)
+		if plan != nil {
+			if len(errs) > numOfErrs {
+				continue
+			}
+			if envOnly, ok := hdlSpec.CtxAttrs.FirstAttrsWithKey("env"); ok {
+				if err := config.ValidateEnvExpr(envOnly.Val); err != nil {
+					errs.Add(hdlSpec.newSetupError("", "%v", err))
+					continue
+				}
+				if !config.IsInEnv(envOnly.Val) {
+					continue
+				}
+			}
+			handlerOf := func(options reflect.Value) gin.HandlerFunc {
+				return func(c *gin.Context) {
+					ctx := WebContext{Context: c, logger: logger}
+					baseParamInstPtrVal, failure := plan.bind(ctx, methodName, options)
+					defer plan.release(baseParamInstPtrVal)
+					if failure != nil {
+						c.AbortWithStatusJSON(failure.status, failure.err)
+					} else if err := valid.Struct(baseParamInstPtrVal.Interface()); err != nil {
+						c.AbortWithStatusJSON(http.StatusBadRequest, plan.validationError(ctx, err))
 					} else {
-						if len(hdlSpec.Method) == 0 {
-							continue
-						}
-						fmt.Printf("[*%v]'s method %d: func %v(%s)\n", instPtrType.Elem().Name(), i, methodName, baseParamType.Name())
-						//fmt.Printf("\t%s\n", baseParamType.Name())
-						wrappers = append(wrappers, HandlerWrapper{
-							hdlSpec,
-							func(c *gin.Context) {
-								ctx := WebContext{c}
-								//fmt.Printf("I'm in")
-								outData := reflect.ValueOf(instPtr).MethodByName(methodName).Call([]reflect.Value{reflect.ValueOf(ctx)})
-								generateOutputData(c, methodName, outData, hdlSpec)
-							},
-						})
+						outData := methodVal.Call([]reflect.Value{baseParamInstPtrVal.Elem()})
+						generateOutputData(c, methodName, outData, hdlSpec, logger, templates)
 					}
 				}
 			}
+			w := HandlerWrapper{Spec: hdlSpec, Handler: handlerOf(reflect.Value{})}
+			if hdlSpec.Options != nil {
+				w.withArgs = func(args string, parse bool) (gin.HandlerFunc, error) {
+					options, err := newMiddlewareOptions(*hdlSpec.Options, args, parse, valid)
+					if err != nil {
+						return nil, err
+					}
+					return handlerOf(options), nil
+				}
+			}
+			wrappers = append(wrappers, w)
+		} else {
+			if len(hdlSpec.Method) == 0 || len(errs) > numOfErrs {
+				continue
+			}
+			wrappers = append(wrappers, HandlerWrapper{
+				Spec: hdlSpec,
+				Handler: func(c *gin.Context) {
+					ctx := WebContext{Context: c, logger: logger}
+					outData := methodVal.Call([]reflect.Value{reflect.ValueOf(ctx)})
+					generateOutputData(c, methodName, outData, hdlSpec, logger, templates)
+				},
+			})
 		}
 	}
-	return wrappers
+	return wrappers, errs.Err()
 }
 
-func analyzeInBaseParam(baseParamType reflect.Type, purpose HandlerWrapperPurpose, hdlSpec *HandlerSpec) {
+func analyzeInBaseParam(baseParamType reflect.Type, purpose HandlerWrapperPurpose, hdlSpec *HandlerSpec, errs *SetupErrors) {
 	fieldsCnt := baseParamType.NumField()
 	if fieldsCnt == 0 {
 		return
@@ -324,6 +335,9 @@ func analyzeInBaseParam(baseParamType reflect.Type, purpose HandlerWrapperPurpos
 	baseKey := purpose.BaseKey()
 	handleMethodRegex := purpose.Regexp()
 	hdlSpec.InFields = make([]BaseParamField, 0, fieldsCnt)
+	numOfFiles := 0
+	numOfStructs := 0
+	var mediaTypes []spec.MediaTypeSupport
 	for f := 0; f < fieldsCnt; f++ {
 		field := baseParamType.Field(f)
 		tag, existsTag := field.Tag.Lookup(HttpTagName)
@@ -353,7 +367,20 @@ func analyzeInBaseParam(baseParamType reflect.Type, purpose HandlerWrapperPurpos
 						hdlSpec.MiddlewareNames = append(hdlSpec.MiddlewareNames, middlewares...)
 						//fmt.Printf("middlewares: %v, diTag: %v\n", middlewares, diTag)
 					}
+					if attr, exists := diTag.FirstAttrsWithKey("concurrency"); exists {
+						if n, err := strconv.Atoi(attr.Val); err != nil || n <= 0 {
+							errs.Add(hdlSpec.newSetupError(field.Name, "concurrency(%s) should be a positive integer", attr.Val))
+						} else {
+							hdlSpec.Concurrency = n
+						}
+					}
+					if attr, exists := diTag.FirstAttrsWithKey("flag"); exists {
+						if hdlSpec.Flags = parseFeatureFlags(attr.Val); len(hdlSpec.Flags) == 0 {
+							errs.Add(hdlSpec.newSetupError(field.Name, "flag should not be empty"))
+						}
+					}
 				}
+				mediaTypes = def.SupportedMediaTypesForRequest()
 				hdlSpec.Description = doc
 				hdlSpec.CtxAttrs = def.Attrs
 				hdlSpec.Security = field.Tag.Get(SecurityTagName)
@@ -361,20 +388,100 @@ func analyzeInBaseParam(baseParamType reflect.Type, purpose HandlerWrapperPurpos
 				//	log.Printf("I Got doc: %s\n", doc)
 				//}
 			} else {
-				log.Fatal("only can embedded WebContext struct.")
+				errs.Add(hdlSpec.newSetupError(field.Name, "only can embedded WebContext struct"))
+				continue
+			}
+		} else if diTag.ContainsAttrWithValOnly("options") {
+			if purpose != HandlerForMid {
+				errs.Add(hdlSpec.newSetupError(field.Name, "options field is only supported by middleware handlers"))
+			} else if hdlSpec.Options != nil {
+				errs.Add(hdlSpec.newSetupError(field.Name, "middleware handler should have one options field at most"))
+			} else {
+				options := def
+				hdlSpec.Options = &options
+			}
+		} else if isContextProvider(field.Type) {
+			def.PreferredName = def.preferredText("name", true, true)
+			if purpose != HandlerForMid {
+				errs.Add(hdlSpec.newSetupError(field.Name, "Provide field is only supported by middleware handlers"))
+			} else {
+				if hdlSpec.Provides == nil {
+					hdlSpec.Provides = map[string]reflect.Type{}
+				}
+				hdlSpec.Provides[def.PreferredName] = reflect.New(field.Type).Interface().(contextProvider).providedType()
 			}
 		} else {
 			def.PreferredName = def.preferredText("name", true, true)
+			if attr, b := diTag.FirstAttrsWithKey("in"); b && !IsCorrectInWay(attr.Val) {
+				errs.Add(hdlSpec.newSetupError(field.Name, "unsupported in way: %s", attr.Val))
+			} else if b && normalizeInWay(attr.Val) == InContextWay {
+				if _, err := missingStatusOf(def); err != nil {
+					errs.Add(hdlSpec.newSetupError(field.Name, "%v", err))
+				}
+			} else if isFileType(field.Type) {
+				if b && attr.Val != InBodyWay {
+					errs.Add(hdlSpec.newSetupError(field.Name, "file field should be in body"))
+				}
+				if _, err := fileLimitsOf(def); err != nil {
+					errs.Add(hdlSpec.newSetupError(field.Name, "%v", err))
+				}
+				if _, exists := diTag.FirstAttrsWithKey("default"); exists {
+					errs.Add(hdlSpec.newSetupError(field.Name, "file field doesn't support default value"))
+				}
+				numOfFiles++
+			} else if spec.GetVariableKind(paramTypeOf(field.Type)) == spec.VarKindUnsupported {
+				errs.Add(hdlSpec.newSetupError(field.Name, "unsupported variable type: %v", field.Type))
+			} else {
+				if isStructBinding(def) {
+					numOfStructs++
+				}
+				if _, _, err := paramStyleOf(def, hdlSpec.inWayOf(def)); err != nil {
+					errs.Add(hdlSpec.newSetupError(field.Name, "%v", err))
+				}
+				if _, exists, err := defaultValueOf(def); err != nil {
+					errs.Add(hdlSpec.newSetupError(field.Name, "%v", err))
+				} else if exists && diTag.ContainsAttrWithValOnly("required") {
+					errs.Add(hdlSpec.newSetupError(field.Name, "required field should not have default value"))
+				}
+			}
 			//fmt.Printf("\t%d[%s][%s] %v\n", def.Index, def.PreferredName, def.FieldSpec.Name, def.FieldSpec.Type)
 		}
 		hdlSpec.InFields = append(hdlSpec.InFields, def)
 	}
+	isBodyMethod := hdlSpec.Method == "post" || hdlSpec.Method == "put" || hdlSpec.Method == "patch"
+	if numOfFiles > 0 && !isBodyMethod {
+		errs.Add(hdlSpec.newSetupError("", "only post, put or patch method support file fields"))
+	}
+	objCoding := len(Filter(mediaTypes, func(mt spec.MediaTypeSupport) bool { return mt.Kind == spec.ObjectiveMediaType }))
+	if objCoding > 0 && objCoding < len(mediaTypes) {
+		errs.Add(hdlSpec.newSetupError("", "obj-coding and form-coding should not set at same time"))
+	}
+	if numOfStructs > 1 && isBodyMethod && (objCoding > 0 || len(mediaTypes) == 0) {
+		// gin reads the json/xml body once, the second struct field always gets EOF.
+		errs.Add(hdlSpec.newSetupError("", "only one struct field can be bound from json body"))
+	}
+	if len(mediaTypes) > 0 && purpose == HandlerForReq && !isBodyMethod {
+		errs.Add(hdlSpec.newSetupError("", "only post, put or patch method support body coding"))
+	}
+	isMultipart := func(mt spec.MediaTypeSupport) bool { return mt.Title == spec.MultipartForm }
+	if numOfFiles > 0 && len(mediaTypes) > 0 && len(Filter(mediaTypes, isMultipart)) == 0 {
+		errs.Add(hdlSpec.newSetupError("", "file fields should be sent by multipart coding"))
+	}
 	return
 }
 
-func analyzeOutBaseParam(baseParamType reflect.Type, _ HandlerWrapperPurpose, hdlSpec *HandlerSpec) {
+// paramTypeOf returns the type which a parameter is described by, a pointer is described by its element.
+func paramTypeOf(typ reflect.Type) reflect.Type {
+	if typ.Kind() == reflect.Pointer {
+		return typ.Elem()
+	}
+	return typ
+}
+
+func analyzeOutBaseParam(baseParamType reflect.Type, _ HandlerWrapperPurpose, hdlSpec *HandlerSpec, errs *SetupErrors) {
 	if baseParamType.Kind() != reflect.Struct {
-		log.Fatalf("only support to return a struct instead of '%v'\n", baseParamType)
+		errs.Add(hdlSpec.newSetupError("", "only support to return a struct instead of '%v'", baseParamType))
+		return
 	}
 	fieldsCnt := baseParamType.NumField()
 	if fieldsCnt == 0 {
@@ -384,7 +491,8 @@ func analyzeOutBaseParam(baseParamType reflect.Type, _ HandlerWrapperPurpose, hd
 	for f := 0; f < fieldsCnt; f++ {
 		field := baseParamType.Field(f)
 		if field.Anonymous || !field.IsExported() {
-			log.Fatalf("field(%s.%s) of returned struct should not be anonymous or un-exported", baseParamType.Name(), field.Name)
+			errs.Add(hdlSpec.newSetupError(field.Name, "field of returned struct should not be anonymous or un-exported"))
+			continue
 		}
 		fieldType := field.Type
 		switch fieldType.Kind() {
@@ -397,14 +505,17 @@ func analyzeOutBaseParam(baseParamType reflect.Type, _ HandlerWrapperPurpose, hd
 			case spec.VarKindObject:
 				// ok
 			default:
-				log.Fatalf("unsupport response type: %s, try to use pointer of struct or base type", fieldType.Name())
+				errs.Add(hdlSpec.newSetupError(field.Name, "unsupported response type: %v, try to use pointer of struct or base type", fieldType))
+				continue
 			}
 		case reflect.Interface:
 			if !IsError(fieldType) {
-				log.Fatalf("unsupport response type: %s, try to use pointer of struct or base type", fieldType.Name())
+				errs.Add(hdlSpec.newSetupError(field.Name, "unsupported response type: %v, try to use pointer of struct or base type", fieldType))
+				continue
 			}
 		default:
-			log.Fatalf("unsupport response type: %s, try to use pointer of struct or base type", fieldType.Name())
+			errs.Add(hdlSpec.newSetupError(field.Name, "unsupported response type: %v, try to use pointer of struct or base type", fieldType))
+			continue
 		}
 
 		tag, existsTag := field.Tag.Lookup(HttpTagName)
@@ -450,7 +561,7 @@ func getPreferredResponseCode(method string) string {
 	return "200"
 }
 
-func generateOutputData(c *gin.Context, method string, output []reflect.Value, hdlSpec HandlerSpec) {
+func generateOutputData(c *gin.Context, method string, output []reflect.Value, hdlSpec HandlerSpec, logger WebLogger, templates *TemplateRenderer) {
 	if len(output) != 1 || len(hdlSpec.OutFields) == 0 {
 		return
 	}
@@ -481,9 +592,21 @@ OutputData:
 			break OutputData
 		}
 
+		// html template
+		if name, layout, ok := templateOfField(field); ok && templates != nil {
+			c.Header("Content-Type", "text/html; charset=utf-8")
+			c.Status(code)
+			// nothing is written if rendering fails, so the status still can be changed.
+			if err := templates.Render(c.Writer, name, layout, fieldValue.Interface()); err != nil {
+				logger.Log(LogError, "render template error", LogKV("method", method), LogKV("template", name), LogKV("error", err))
+				c.Writer.Header().Del("Content-Type")
+				c.AbortWithStatus(http.StatusInternalServerError)
+			}
+			break OutputData
+		}
+
 		// text format
 		if text, ok := v.(string); ok {
-			log.Printf("******* %s *********\n", text)
 			c.Data(code, string(format), []byte(text))
 			break OutputData
 		}
@@ -513,7 +636,7 @@ OutputData:
 			// TODO: implement reader or something?
 		}
 
-		log.Printf("unsupported response format(%s)", format)
+		logger.Log(LogWarn, "unsupported response format", LogKV("method", method), LogKV("format", format))
 		break
 	}
 }
@@ -5,14 +5,16 @@
 package dij_gin
 
 import (
+	"context"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
 	"github.com/letscool/dij-gin/spec"
 	"github.com/letscool/lc-go/dij"
 	. "github.com/letscool/lc-go/lg"
-	"log"
+	"net/http"
 	"reflect"
+	"strconv"
 	"strings"
 )
 
@@ -24,6 +26,8 @@ const (
 	RefKeyForWebSpecRecord = "_.webserver.spec.record"
 	RefKeyForWebValidator  = "_.webserver.validator"
 	RefKeyForWebDijRef     = "_.webserver.dij.ref"
+	RefKeyForWebRouteTable = "_.webserver.route.table"
+	RefKeyForWebLogger     = "_.webserver.logger"
 )
 
 func PrepareGin(webServerTypeOrInst any, others ...any) (*gin.Engine, dij.DependencyReferencePtr, error) {
@@ -43,19 +47,24 @@ func PrepareGin(webServerTypeOrInst any, others ...any) (*gin.Engine, dij.Depend
 	ref := dij.DependencyReference{}
 	// setup web config
 	config := NewWebConfig()
+	var unknownOthers []any
 	for _, other := range others {
-		otherTyp := reflect.TypeOf(other)
 		switch v := other.(type) {
 		case WebConfig:
 			config = &v
 		case *WebConfig:
 			config = v
 		default:
-			log.Println("No ideal about type:", otherTyp, " value:", other)
+			unknownOthers = append(unknownOthers, other)
 		}
 	}
 	config.ApplyDefaultValues()
 	ref[RefKeyForWebConfig] = config
+	logger := config.GetLogger()
+	ref[RefKeyForWebLogger] = logger
+	for _, other := range unknownOthers {
+		logger.Log(LogWarn, "no idea about the launch option", LogKV("type", reflect.TypeOf(other)), LogKV("value", other))
+	}
 	gin.DefaultWriter = config.DefaultWriter
 	//
 	for k, v := range config.DependentRefs {
@@ -109,6 +118,25 @@ func PrepareGin(webServerTypeOrInst any, others ...any) (*gin.Engine, dij.Depend
 	v := validator.New()
 	v.SetTagName(config.ValidatorTagName)
 	ref[RefKeyForWebValidator] = v
+	// setup html templates
+	if config.Template.IsEnabled() {
+		renderer, err := NewTemplateRenderer(config.Template, config.Template.isReloading(config.BaseEnv()))
+		if err != nil {
+			return nil, nil, err
+		}
+		ref[RefKeyForWebTemplates] = renderer
+	}
+	// setup route table
+	routeTable := &RouteTable{}
+	ref[RefKeyForWebRouteTable] = routeTable
+	noRoute := &NoRouteChain{}
+	ref[RefKeyForWebNoRoute] = noRoute
+	// setup dynamic routes, they are matched before other fallbacks of no route.
+	dynamic := newDynamicRoutes(&ref)
+	ref[RefKeyForWebDynamicRoutes] = dynamic
+	noRoute.AddFallback(dynamic.fallback)
+	registry := newMiddlewareRegistry()
+	ref[RefKeyForWebMiddlewares] = registry
 	// save ref self
 	ref[RefKeyForWebDijRef] = &ref
 	// create instance
@@ -118,21 +146,34 @@ func PrepareGin(webServerTypeOrInst any, others ...any) (*gin.Engine, dij.Depend
 		var inst any
 		inst, err = dij.BuildAnyInstance(webServerInst, &ref, "^")
 		if err == nil && inst != webServerInst {
-			log.Fatalf("instance is not original instance, it should be a bug.\n%p != %p\n%v",
-				inst, webServerInst, err)
+			err = fmt.Errorf("instance is not original instance, it should be a bug. %p != %p", inst, webServerInst)
 		}
 	} else {
 		webServerInst, err = dij.CreateInstance(webServerType, &ref, "^")
 	}
 	if err != nil {
-		log.Panic(err)
+		return nil, nil, err
 	}
 
-	router := gin.Default()
+	router, err := newGinEngine(config)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	if err := setupRouterHandlers(webServerInst, webServerType, router, &ref); err != nil {
+	// collect all misconfigurations, instead of stopping at the first one.
+	var errs SetupErrors
+	registry.registerConfigMiddlewares(config, &errs)
+	registry.registerMiddlewares(webServerInst, webServerType, &ref, &errs)
+	registry.setGlobals(config.GlobalMiddlewares, &errs)
+	// global middlewares wrap all routes, NoRoute and NoMethod handlers of the engine.
+	router.Use(middlewareHandlersOf(registry.globals)...)
+	setupRouterHandlers(webServerInst, webServerType, router, routerScope{mounted: map[any]string{}}, &ref, &errs)
+	if err := errs.Err(); err != nil {
 		return nil, nil, err
 	}
+	noRoute.install(router)
+	dynamic.ready()
+	logger.Log(LogInfo, "registered routes\n"+routeTable.String())
 
 	return router, &ref, nil
 }
@@ -154,20 +195,47 @@ func PrepareGin(webServerTypeOrInst any, others ...any) (*gin.Engine, dij.Depend
 //	  webInst = &WebSer{}
 //	  LaunchGin(webInst) // launch by instance
 //	}
+//
+// LaunchGin blocks until the web server is shut down by SIGINT/SIGTERM, in-flight requests
+// will be drained gracefully, see StartGin for more control.
 func LaunchGin(webServerTypeOrInst any, others ...any) error {
-	engine, refPtr, err := PrepareGin(webServerTypeOrInst, others...)
+	handle, err := StartGin(context.Background(), webServerTypeOrInst, others...)
 	if err != nil {
 		return err
 	}
-	v, _ := refPtr.Get(RefKeyForWebConfig)
-	config := v.(*WebConfig)
+	return handle.Wait()
+}
+
+// routerScope is inherited from a controller to its extenders.
+type routerScope struct {
+	middlewares []scopedMiddleware // middlewares inherited from predecessors
+	flags       []string           // feature flags which should be all on
+	mounts      []string           // names of mounts from the root controller, for operationId
+	mount       *mountPoint        // mount of the controller, it overrides the http tag of embedded WebController and isn't inherited.
+	mounted     map[any]string     // mount names by controller instances, an instance keeps the state of one mount only.
+}
 
-	addr := fmt.Sprintf("%v:%d", config.Address, Ife(config.Port <= 0, DefaultWebServerPort, config.Port))
-	return engine.Run(addr)
+// fieldInstanceOf returns the instance of a middleware or controller field, which is injected by dij or set by user.
+// ok is false if the field is zero.
+func fieldInstanceOf(instPtr any, instType reflect.Type, idx int, refPtr dij.DependencyReferencePtr) (fieldIf any, ok bool) {
+	if fieldIf, ok = refPtr.GetForDiField(instType, idx); ok {
+		return
+	}
+	field := instType.Field(idx)
+	fieldValue := reflect.ValueOf(instPtr).Elem().Field(idx)
+	if fieldValue.IsZero() {
+		return nil, false
+	}
+	if field.IsExported() {
+		return fieldValue.Interface(), true
+	}
+	return reflect.NewAt(field.Type, fieldValue.Addr().UnsafePointer()).Elem().Interface(), true
 }
 
-func setupRouterHandlers(instPtr any, instType reflect.Type, router WebRouter, refPtr dij.DependencyReferencePtr) error {
-	rtEnv := ((*refPtr)[RefKeyForWebConfig].(*WebConfig)).RtEnv
+// setupRouterHandlers sets routing for the controller and its extenders.
+func setupRouterHandlers(instPtr any, instType reflect.Type, router WebRouter, scope routerScope, refPtr dij.DependencyReferencePtr, errs *SetupErrors) {
+	config := (*refPtr)[RefKeyForWebConfig].(*WebConfig)
+	logger := (*refPtr)[RefKeyForWebLogger].(WebLogger)
 	predecessor := make([]int, 0)
 	plugins := make([]int, 0)
 	extenders := make([]int, 0)
@@ -185,87 +253,86 @@ func setupRouterHandlers(instPtr any, instType reflect.Type, router WebRouter, r
 		}
 	}
 
-	// prepare middlewares
-	mwHdlWrappers := map[string]HandlerWrapper{}
-	if len(plugins) > 0 {
-		for _, idx := range plugins {
-			field := instType.Field(idx)
-			fieldTyp := field.Type
-			fmt.Printf("Middleware: %v from %v\n", fieldTyp, instType)
-			if fieldTyp.Kind() != reflect.Pointer || fieldTyp.Elem().Kind() != reflect.Struct {
-				return fmt.Errorf("middleware's type(%v) should be a kind of struct point", fieldTyp)
-			}
-			instValue := reflect.ValueOf(instPtr).Elem()
-			var fieldIf any
-			var exists bool
-			fieldIf, exists = refPtr.GetForDiField(instType, idx)
-			if !exists {
-				fieldValue := instValue.Field(idx)
-				if fieldValue.IsZero() {
-					return fmt.Errorf("fieldSpec(%s) should not be zero", fieldTyp.Name())
-				}
-				if field.IsExported() {
-					fieldIf = fieldValue.Interface()
-				} else {
-					fieldIf = reflect.NewAt(fieldTyp, fieldValue.Addr().UnsafePointer()).Elem().Interface()
-				}
-			} else {
-				//fmt.Printf("middleware load from dij: %v\n", fieldTyp)
-			}
-			wrappers := GenerateHandlerWrappers(fieldIf, HandlerForMid, refPtr)
-			for _, w := range wrappers {
-				fmt.Printf("%v %v\n", w.ReqMethod(), w.ReqPath())
-				_, exists := mwHdlWrappers[w.ReqPath()]
-				if exists {
-					return fmt.Errorf("middleware's handler '%s' is duplicated", w.ReqPath())
-				}
-				mwHdlWrappers[w.ReqPath()] = w
-			}
+	// check middlewares, they have been registered into MiddlewareRegistry before routes are set up.
+	for _, idx := range plugins {
+		field := instType.Field(idx)
+		fieldTyp := field.Type
+		logger.Log(LogDebug, "setup middleware", LogKV("controller", instType), LogKV("middleware", fieldTyp))
+		if fieldTyp.Kind() != reflect.Pointer || fieldTyp.Elem().Kind() != reflect.Struct {
+			errs.Add(newSetupError(instType, field.Name, "middleware's type(%v) should be a kind of struct point", fieldTyp))
+			continue
+		}
+		if _, ok := fieldInstanceOf(instPtr, instType, idx, refPtr); !ok {
+			errs.Add(newSetupError(instType, field.Name, "middleware(%v) should not be zero", fieldTyp))
 		}
 	}
+	mwHdlWrappers := GetMiddlewareRegistry(refPtr).wrappers
 
 	// setup current router
 	if len(predecessor) != 1 {
-		return fmt.Errorf("struct '%s' should embeded web controller or web server.(%d)", instType.Name(), len(predecessor))
+		errs.Add(newSetupError(instType, "", "struct should embed one web controller or web server.(%d)", len(predecessor)))
+		return
 	} else {
-		// TODO: base controller has some middlewares installed, and extended controllers also inherited those middlewares, why? fix it ????
 		routers := router.(gin.IRoutes)
 		field := instType.Field(predecessor[0])
 		var apiTag string
-		if tag, exists := field.Tag.Lookup(HttpTagName); exists {
-			attrs := ParseStructTag(tag)
+		tag, exists := field.Tag.Lookup(HttpTagName)
+		attrs := ParseStructTag(tag)
+		if mount := scope.mount; mount != nil {
+			attrs = mergeHttpTag(attrs, mount.attrs)
+			exists = true
+			scope.mounts = append(scope.mounts[:len(scope.mounts):len(scope.mounts)], mount.name)
+			scope.mount = nil
+		}
+		// middlewares of the controller apply to its handlers, and to its extenders if "inherit" is set.
+		chain := scope.middlewares
+		if exists {
 			if envOnly, ok := attrs.FirstAttrsWithKey("env"); ok {
-				if !rtEnv.IsInOnlyEnv(envOnly.Val) {
-					return nil
+				if err := config.ValidateEnvExpr(envOnly.Val); err != nil {
+					errs.Add(newSetupError(instType, field.Name, "%v", err))
+					return
+				}
+				if !config.IsInEnv(envOnly.Val) {
+					return
 				}
 			}
 			if apiTagAttr, ok := attrs.FirstAttrsWithKey("tag"); ok {
 				apiTag = strings.TrimSpace(apiTagAttr.Val)
 			}
+			if attr, ok := attrs.FirstAttrsWithKey("flag"); ok {
+				if flags := parseFeatureFlags(attr.Val); len(flags) == 0 {
+					errs.Add(newSetupError(instType, field.Name, "flag should not be empty"))
+				} else {
+					scope.flags = append(scope.flags[:len(scope.flags):len(scope.flags)], flags...)
+				}
+			}
 			if path, existsPath := attrs.PreferredName("path", true); existsPath && len(path) > 0 {
 				router = router.Group(path)
 			}
 			routers = router.(gin.IRoutes)
 			if attr, exists := attrs.FirstAttrsWithKey("middleware"); exists {
-				middlewares := strings.Split(attr.Val, "&")
-				for _, m := range middlewares {
-					if name := strings.TrimSpace(m); len(name) > 0 {
-						if w, b := mwHdlWrappers[name]; !b {
-							return fmt.Errorf("middleware's handler '%s' doesn't exist", name)
-						} else {
-							routers = routers.Use(w.Handler)
-						}
-					}
+				newErr := func(format string, args ...any) *SetupError {
+					return newSetupError(instType, field.Name, format, args...)
+				}
+				names, optOuts := splitMiddlewareNames(strings.Split(attr.Val, "&"))
+				scope.middlewares = optOutMiddlewares(scope.middlewares, optOuts, newErr, errs)
+				chain = appendMiddlewares(scope.middlewares, names, mwHdlWrappers, newErr, errs)
+				if attrs.ContainsAttrWithValOnly("inherit") {
+					scope.middlewares = chain
+				} else if len(extenders) > 0 && len(names) > 0 {
+					// before inherit was introduced, middlewares of a controller always applied to its extenders.
+					logger.Log(LogWarn, "middlewares don't apply to extenders without inherit attribute",
+						LogKV("controller", instType), LogKV("middlewares", names))
 				}
 			}
 		}
-		fmt.Printf("Set router for %v\n", instType)
 		if webRoutes, ok := routers.(WebRoutes); ok {
-			setupRoutesHandlers(webRoutes, instPtr, mwHdlWrappers, refPtr, apiTag)
+			// routes of handlers are added into the router with their own chains, so handlers can opt out.
+			setupRoutesHandlers(webRoutes, instPtr, mwHdlWrappers, chain, scope, refPtr, apiTag, errs)
 			ctrl := instPtr.(WebControllerSpec)
-			ctrl.SetupRouter(router, instPtr)
+			ctrl.SetupRouter(router.Group("", middlewareHandlersOf(chain)...), instPtr, attrs, errs)
 		} else {
-			log.Fatalln("IRoutes doesn't have BasePath??? Fix it.")
+			errs.Add(newSetupError(instType, "", "IRoutes(%v) doesn't have BasePath", reflect.TypeOf(routers)))
 		}
 	}
 
@@ -274,76 +341,112 @@ func setupRouterHandlers(instPtr any, instType reflect.Type, router WebRouter, r
 		for _, idx := range extenders {
 			field := instType.Field(idx)
 			fieldTyp := field.Type
-			fmt.Printf("Extender: %v from %v\n", fieldTyp, instType)
+			logger.Log(LogDebug, "setup extender", LogKV("controller", instType), LogKV("extender", fieldTyp))
 			if fieldTyp.Kind() != reflect.Pointer || fieldTyp.Elem().Kind() != reflect.Struct {
-				return fmt.Errorf("appending controller's type(%v) should be a kind of struct point", fieldTyp)
+				errs.Add(newSetupError(instType, field.Name, "appending controller's type(%v) should be a kind of struct point", fieldTyp))
+				continue
+			}
+			fieldIf, ok := fieldInstanceOf(instPtr, instType, idx, refPtr)
+			if !ok {
+				errs.Add(newSetupError(instType, field.Name, "appending controller(%v) should not be zero", fieldTyp))
+				continue
 			}
 			instValue := reflect.ValueOf(instPtr).Elem()
-			var fieldIf any
-			var exists bool
-			fieldIf, exists = refPtr.GetForDiField(instType, idx)
-			if !exists {
-				fieldValue := instValue.Field(idx)
-				if fieldValue.IsZero() {
-					return fmt.Errorf("fieldSpec(%s) should not be zero", fieldTyp.Name())
+			extenderScope, extenderRef := scope, refPtr
+			if mount, ok := newMountPoint(field, config); ok {
+				if extenderRef = mountRef(refPtr, config.Mounts[mount.name]); extenderRef != refPtr {
+					// the mount has its own instance which is injected with overridden DependentRefs.
+					inst, err := dij.CreateInstance(fieldTyp.Elem(), extenderRef, "^")
+					if err != nil {
+						errs.Add(newSetupError(instType, field.Name, "create instance for mount(%s) error: %v", mount.name, err))
+						continue
+					}
+					fieldIf = inst
+					if field.IsExported() {
+						instValue.Field(idx).Set(reflect.ValueOf(inst))
+					} else {
+						dij.SetUnexportedField(instValue.Field(idx), inst)
+					}
 				}
-				if field.IsExported() {
-					fieldIf = fieldValue.Interface()
-				} else {
-					fieldIf = reflect.NewAt(fieldTyp, fieldValue.Addr().UnsafePointer()).Elem().Interface()
+				if other, exists := scope.mounted[fieldIf]; exists {
+					errs.Add(newSetupError(instType, field.Name, "mount(%s) shares the controller instance with mount(%s), they should have different di names", mount.name, other))
+					continue
 				}
-			} else {
-				//fmt.Printf("extenders load from dij: %v\n", fieldTyp)
-			}
-			if err := setupRouterHandlers(fieldIf, fieldTyp.Elem(), router, refPtr); err != nil {
-				return err
+				scope.mounted[fieldIf] = mount.name
+				extenderScope.mount = &mount
 			}
+			setupRouterHandlers(fieldIf, fieldTyp.Elem(), router, extenderScope, extenderRef, errs)
 		}
 	}
-
-	// all done
-	return nil
 }
 
 // setupRoutesHandlers set routing path for controller
-func setupRoutesHandlers(routes WebRoutes, instPtr any, mwHdlWrappers map[string]HandlerWrapper, refPtr dij.DependencyReferencePtr, apiTag string) {
+func setupRoutesHandlers(routes WebRoutes, instPtr any, mwHdlWrappers map[string]HandlerWrapper, chain []scopedMiddleware, scope routerScope, refPtr dij.DependencyReferencePtr, apiTag string, errs *SetupErrors) {
 	basePath := routes.BasePath()
-	wrappers := GenerateHandlerWrappers(instPtr, HandlerForReq, refPtr)
+	config := (*refPtr)[RefKeyForWebConfig].(*WebConfig)
+	routeTable := (*refPtr)[RefKeyForWebRouteTable].(*RouteTable)
+	logger := (*refPtr)[RefKeyForWebLogger].(WebLogger)
+	wrappers, err := GenerateHandlerWrappers(instPtr, HandlerForReq, refPtr)
+	errs.Append(err)
 	var openapiSpec *spec.Openapi
 	if _, ok := (*refPtr)[RefKeyForWebSpecRecord]; ok {
 		openapiSpec = (*refPtr)[RefKeyForWebSpecRecord].(*spec.Openapi)
 	}
 	for _, w := range wrappers {
+		numOfErrs := len(*errs)
 		// process gin structure
-		var handlers []gin.HandlerFunc
-		for _, name := range w.Spec.MiddlewareNames {
-			if name = strings.TrimSpace(name); len(name) > 0 {
-				if h, b := mwHdlWrappers[name]; b {
-					handlers = append(handlers, h.Handler)
-				} else {
-					log.Fatalf("middleware's handler '%s' doesn't exist", name)
-				}
+		newErr := func(format string, args ...any) *SetupError {
+			return w.Spec.newSetupError("", format, args...)
+		}
+		// middlewares of the handler run after the chain of controller, feature flags and concurrency limit,
+		// but a middleware in the chain is overridden at its position.
+		names, optOuts := splitMiddlewareNames(w.Spec.MiddlewareNames)
+		handlerChain := optOutMiddlewares(chain, optOuts, newErr, errs)
+		numOfInherited := len(handlerChain)
+		handlerChain = appendMiddlewares(handlerChain, names, mwHdlWrappers, newErr, errs)
+		handlers := middlewareHandlersOf(handlerChain[:numOfInherited])
+		flags := uniqueFeatureFlags(append(append([]string{}, scope.flags...), w.Spec.Flags...))
+		if len(flags) > 0 {
+			if provider, ok := GetFeatureFlagProvider(refPtr); ok {
+				handlers = append(handlers, featureFlagHandler(provider, flags, config.FlagOffStatus))
+			} else {
+				errs.Add(w.Spec.newSetupError("", "feature flags(%s) need a FeatureFlagProvider in DependentRefs", strings.Join(flags, "&")))
 			}
 		}
+		if w.Spec.Concurrency > 0 {
+			handlers = append(handlers, concurrencyLimitHandler(w.Spec.Concurrency))
+		}
+		handlers = append(handlers, middlewareHandlersOf(handlerChain[numOfInherited:])...)
+		checkContextValues(&w.Spec, append(GetMiddlewareRegistry(refPtr).globals, handlerChain...), errs)
+		middlewareNames := append(GetMiddlewareRegistry(refPtr).Globals(), middlewareNamesOf(handlerChain)...)
+		if len(*errs) > numOfErrs {
+			continue
+		}
 		handlers = append(handlers, w.Handler)
 		method := w.ReqMethod()
 		if strings.HasPrefix(method, "no") {
 			//log.Printf("***** Routes type: %v, %v, isEngine: %v, path=%s", reflect.TypeOf(routes), reflect.TypeOf(routes).Elem(), isEngine, routes.BasePath())
 			if engine, isEngine := routes.(*gin.Engine); isEngine {
 				if method == "noroute" {
-					engine.NoRoute(handlers...)
+					// installed after all controllers are set up, so fallbacks of controllers can run before it.
+					GetNoRouteChain(refPtr).setHandlers(handlers)
+					routeTable.Add(newRouteInfo(w, instPtr, basePath, middlewareNames, flags, scope.mounts))
 				} else if method == "nomethod" {
 					engine.NoMethod(handlers...)
+					routeTable.Add(newRouteInfo(w, instPtr, basePath, middlewareNames, flags, scope.mounts))
 				} else {
-					log.Printf("unsupported no %s, what is this?\n", method[2:])
+					logger.Log(LogWarn, "unsupported handler", LogKV("controller", reflect.TypeOf(instPtr).Elem()),
+						LogKV("method", w.Spec.MethodType.Name))
 				}
 			} else {
-				log.Printf("warning!! no %s is only supported in root path, current is '%s'\n", method[2:], basePath)
+				logger.Log(LogWarn, fmt.Sprintf("no %s is only supported in root path", method[2:]),
+					LogKV("controller", reflect.TypeOf(instPtr).Elem()), LogKV("method", w.Spec.MethodType.Name), LogKV("path", basePath))
 			}
 			// not support openapi yet
 			continue
 		} else {
 			routes.Handle(w.UpperReqMethod(), w.ReqPath(), handlers...)
+			routeTable.Add(newRouteInfo(w, instPtr, basePath, middlewareNames, flags, scope.mounts))
 		}
 
 		// check openapi is enabled
@@ -355,20 +458,16 @@ func setupRoutesHandlers(routes WebRoutes, instPtr any, mwHdlWrappers map[string
 
 		var parameters spec.ParameterList
 		var bodySchemas []spec.SchemaR
+		var fileFields []BaseParamField
 		var reqBody *spec.RequestBodyR
 		shouldBodyCoding := method == "post" || method == "put" || method == "patch"
 		reqMime := make([]spec.MediaTypeTitle, 0) // "application/x-www-form-urlencoded", "multipart/form-data", "application/json"
-		var objCoding, formCoding int
+		// codings have been validated in analyzeInBaseParam
 		for _, fieldDef := range w.Spec.InFields {
 			fieldSpec := fieldDef.FieldSpec
 			fieldSpecType := fieldSpec.Type
 			if fieldSpec.Anonymous && fieldSpecType == WebCtxType {
 				for _, mt := range fieldDef.SupportedMediaTypesForRequest() {
-					if mt.Kind == spec.ObjectiveMediaType {
-						objCoding++
-					} else {
-						formCoding++
-					}
 					reqMime = append(reqMime, mt.Title)
 				}
 				if apiTagAttr, ok := fieldDef.Attrs.FirstAttrsWithKey("tag"); ok {
@@ -379,47 +478,36 @@ func setupRoutesHandlers(routes WebRoutes, instPtr any, mwHdlWrappers map[string
 				// later
 			}
 		}
-		if objCoding > 0 && formCoding > 0 {
-			log.Fatalf("Obj-coding and form-coding should not set at same time.")
-		}
-		if len(reqMime) > 0 && !shouldBodyCoding {
-			log.Fatalf("Only post or put method support body coding")
-		}
 		var preferPlainCoding, preferObjCoding int
+		var missingStatuses []int
+		hasParams := false
 		for _, fieldDef := range w.Spec.InFields {
 			fieldSpec := fieldDef.FieldSpec
 			fieldSpecType := fieldSpec.Type
 			attrs := fieldDef.Attrs
 			if fieldSpec.Anonymous && fieldSpecType == WebCtxType {
 				// ignore
+			} else if isInContextWay(fieldDef) {
+				// not a part of request, but the request fails if the value is missing.
+				if status, _ := missingStatusOf(fieldDef); status != http.StatusInternalServerError {
+					missingStatuses = append(missingStatuses, status)
+				}
+			} else if isFileType(fieldSpecType) {
+				hasParams = true
+				fileFields = append(fileFields, fieldDef)
 			} else {
-				var inWay InWay
+				// unsupported types have been reported in analyzeInBaseParam
+				fieldSpecType = paramTypeOf(fieldSpecType)
 				varKind := spec.GetVariableKind(fieldSpecType)
-				if varKind == spec.VarKindUnsupported {
-					log.Fatalf("unsupport variable type: %v", fieldSpecType)
-				}
-				if Contains(pathParamNames, fieldDef.PreferredName) {
-					inWay = InPathWay
-				} else {
-					if attr, b := attrs.FirstAttrsWithKey("in"); b {
-						in := attr.Val
-						if !IsCorrectInWay(in) {
-							log.Fatalf("unsupported in way: %s", in)
-						}
-						inWay = in
-					} else {
-						switch varKind {
-						case spec.VarKindArray, spec.VarKindObject:
-							preferObjCoding++
-						default:
-							preferPlainCoding++
-						}
-						// use default way
-						if shouldBodyCoding {
-							inWay = InBodyWay
-						} else {
-							inWay = InQueryWay
-						}
+				hasParams = true
+				// the way has been validated in analyzeInBaseParam
+				inWay := w.InWayOf(fieldDef, pathParamNames)
+				if _, b := attrs.FirstAttrsWithKey("in"); !b && inWay != InPathWay {
+					switch varKind {
+					case spec.VarKindArray, spec.VarKindObject:
+						preferObjCoding++
+					default:
+						preferPlainCoding++
 					}
 				}
 				//
@@ -427,6 +515,9 @@ func setupRoutesHandlers(routes WebRoutes, instPtr any, mwHdlWrappers map[string
 					// request body
 					schema := spec.SchemaR{}
 					schema.ApplyType(fieldSpecType)
+					if value, exists, _ := defaultValueOf(fieldDef); exists && schema.Schema != nil {
+						schema.Default = value.Interface()
+					}
 					bodySchemas = append(bodySchemas, schema)
 				} else {
 					// parameters
@@ -436,14 +527,26 @@ func setupRoutesHandlers(routes WebRoutes, instPtr any, mwHdlWrappers map[string
 						Description: fieldDef.Description,
 					}
 					paramSpec.ApplyType(fieldSpecType)
+					if style, explicit, _ := paramStyleOf(fieldDef, inWay); explicit || varKind != spec.VarKindBase {
+						paramSpec.Style = style.style
+						paramSpec.Explode = &style.explode
+					}
 					if attrs.ContainsAttrWithValOnly("required") {
 						paramSpec.Required = true
 					}
+					if value, exists, _ := defaultValueOf(fieldDef); exists && paramSpec.Schema.Schema != nil {
+						paramSpec.Schema.Default = value.Interface()
+					}
 
 					parameters = parameters.AppendParam(&paramSpec)
 				}
 			}
 		}
+		if len(fileFields) > 0 {
+			if len(reqMime) == 0 {
+				reqMime = append(reqMime, spec.MultipartForm)
+			}
+		}
 		if shouldBodyCoding && len(reqMime) == 0 {
 			if preferObjCoding > 0 {
 				reqMime = append(reqMime, spec.JsonObject)
@@ -477,6 +580,36 @@ func setupRoutesHandlers(routes WebRoutes, instPtr any, mwHdlWrappers map[string
 			code := fieldDef.PreferredName
 			responses[code] = spec.ResponseR{Response: &resp}
 		}
+		if w.Spec.Concurrency > 0 {
+			if _, exists := responses["503"]; !exists {
+				schema := spec.SchemaR{}
+				schema.ApplyType(TypeOfWebError)
+				responses["503"] = spec.ResponseR{Response: &spec.Response{
+					Content:     spec.Content{spec.JsonObject: spec.MediaType{Schema: &schema}},
+					Description: "Too many concurrent requests, retry after the time in Retry-After header.",
+				}}
+			}
+		}
+
+		if _, exists := responses["400"]; hasParams && !exists {
+			schema := spec.SchemaR{}
+			schema.ApplyType(TypeOfWebError)
+			responses["400"] = spec.ResponseR{Response: &spec.Response{
+				Content:     spec.Content{spec.JsonObject: spec.MediaType{Schema: &schema}},
+				Description: "Parameters fail to bind or validate, the details list the incorrect parameters.",
+			}}
+		}
+
+		for _, status := range missingStatuses {
+			if _, exists := responses[strconv.Itoa(status)]; !exists {
+				schema := spec.SchemaR{}
+				schema.ApplyType(TypeOfWebError)
+				responses[strconv.Itoa(status)] = spec.ResponseR{Response: &spec.Response{
+					Content:     spec.Content{spec.JsonObject: spec.MediaType{Schema: &schema}},
+					Description: http.StatusText(status),
+				}}
+			}
+		}
 
 		if shouldBodyCoding {
 			// At this moment, doesn't support ref RequestBody
@@ -497,8 +630,13 @@ func setupRoutesHandlers(routes WebRoutes, instPtr any, mwHdlWrappers map[string
 				reqBody.Required = true
 			}
 
+			var encoding map[string]spec.Encoding
+			if len(fileFields) > 0 {
+				mainSchema, encoding = multipartSchemaOf(&w, pathParamNames)
+				reqBody.Required = len(mainSchema.Required) > 0
+			}
 			for _, coding := range reqMime {
-				reqBody.SetMediaType(coding, spec.MediaType{Schema: &mainSchema})
+				reqBody.SetMediaType(coding, spec.MediaType{Schema: &mainSchema, Encoding: encoding})
 			}
 		}
 
@@ -530,6 +668,7 @@ func setupRoutesHandlers(routes WebRoutes, instPtr any, mwHdlWrappers map[string
 		}
 
 		operation := spec.Operation{
+			OperationID: openapiSpec.UniqueOperationId(operationIdOf(scope.mounts, w.Spec.MethodType.Name)),
 			Parameters:  parameters,
 			RequestBody: reqBody,
 			Responses:   responses,
@@ -537,6 +676,47 @@ func setupRoutesHandlers(routes WebRoutes, instPtr any, mwHdlWrappers map[string
 			Tags:        tags,
 			Security:    securityRequirement,
 		}
+		if w.Spec.Concurrency > 0 {
+			operation.SetExtension(OpenApiConcurrencyLimit, w.Spec.Concurrency)
+		}
+		if len(flags) > 0 {
+			operation.SetExtension(OpenApiFeatureFlags, flags)
+		}
 		openapiSpec.AddPathOperation(fullPath, method, operation)
 	}
 }
+
+// newRouteInfo collects information of a registered route for RouteTable.
+func newRouteInfo(w HandlerWrapper, instPtr any, basePath string, middlewareNames []string, flags []string, mounts []string) RouteInfo {
+	info := RouteInfo{
+		Method:      w.UpperReqMethod(),
+		Path:        JoinRoutePath(basePath, w.ReqPath()),
+		Controller:  reflect.TypeOf(instPtr).Elem().String(),
+		Handler:     w.Spec.MethodType.Name,
+		Middlewares: middlewareNames,
+		Security:    w.Spec.Security,
+		Concurrency: w.Spec.Concurrency,
+		Flags:       flags,
+		Mount:       strings.Join(mounts, "."),
+	}
+	if strings.HasPrefix(w.ReqMethod(), "no") {
+		info.Path = basePath
+	}
+	if envOnly, ok := w.Spec.CtxAttrs.FirstAttrsWithKey("env"); ok {
+		info.Env = envOnly.Val
+	}
+	_, pathParamNames := w.ConcatOpenapiPath(basePath)
+	for _, def := range w.Spec.InFields {
+		if def.FieldSpec.Anonymous && def.FieldSpec.Type == WebCtxType {
+			continue
+		}
+		inWay := w.InWayOf(def, pathParamNames)
+		info.Params = append(info.Params, RouteParam{
+			Name:     def.PreferredName,
+			In:       inWay,
+			Type:     def.FieldSpec.Type.String(),
+			Required: inWay == InPathWay || def.Attrs.ContainsAttrWithValOnly("required"),
+		})
+	}
+	return info
+}
@@ -5,16 +5,33 @@
 package dij_gin_test
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
 	. "github.com/letscool/dij-gin"
 	"github.com/letscool/dij-gin/libs"
+	"github.com/letscool/dij-gin/spec"
+	"io"
 	"log"
+	"mime/multipart"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
 	"reflect"
 	"regexp"
 	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
 type TestByWebServerValue struct {
@@ -307,3 +324,2141 @@ func TestValidator(t *testing.T) {
 		}
 	})
 }
+
+type TestLifecycleServer struct {
+	WebServer
+
+	ctrl  *TestLifecycleController      `di:"^"`
+	admin *TestLifecycleAdminController `di:"^"`
+}
+
+func (s *TestLifecycleServer) WillShutdown() {
+	testLifecycleEvents = append(testLifecycleEvents, "server.will")
+}
+
+func (s *TestLifecycleServer) DidShutdown() {
+	testLifecycleEvents = append(testLifecycleEvents, "server.did")
+}
+
+type TestLifecycleController struct {
+	WebController `http:"life"`
+
+	store *TestLifecycleStore `di:""`
+}
+
+type TestLifecycleAdminController struct {
+	WebController `http:"admin"`
+
+	store *TestLifecycleStore `di:""` // shared with TestLifecycleController
+}
+
+func (c *TestLifecycleAdminController) WillShutdown() {
+	testLifecycleEvents = append(testLifecycleEvents, "admin.will")
+}
+
+func (c *TestLifecycleAdminController) DidShutdown() {
+	testLifecycleEvents = append(testLifecycleEvents, "admin.did")
+}
+
+type TestLifecycleStore struct {
+	onClose any `di:"lifecycle.onClose"` // interfaces holding a func and a map aren't keys of instances
+	labels  any `di:"lifecycle.labels"`
+}
+
+func (s *TestLifecycleStore) WillShutdown() {
+	testLifecycleEvents = append(testLifecycleEvents, "store.will")
+}
+
+func (s *TestLifecycleStore) DidShutdown() {
+	testLifecycleEvents = append(testLifecycleEvents, "store.did")
+}
+
+func (c *TestLifecycleController) GetPing(ctx WebContext) {
+	ctx.String(http.StatusOK, "pong")
+}
+
+func (c *TestLifecycleController) WillShutdown() {
+	testLifecycleEvents = append(testLifecycleEvents, "ctrl.will")
+}
+
+func (c *TestLifecycleController) DidShutdown() {
+	testLifecycleEvents = append(testLifecycleEvents, "ctrl.did")
+}
+
+var testLifecycleEvents []string
+
+// go test ./ -v -run TestStartGin
+func TestStartGin(t *testing.T) {
+	testLifecycleEvents = nil
+	ctx, cancel := context.WithCancel(context.Background())
+	config := NewWebConfig().SetAddress("localhost").SetPort(freePort(t)).SetShutdownTimeout(time.Second).
+		SetDependentRef("lifecycle.onClose", func() {}).SetDependentRef("lifecycle.labels", map[string]string{})
+	handle, err := StartGin(ctx, &TestLifecycleServer{}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get("http://" + handle.Addr() + "/life/ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if string(body) != "pong" {
+		t.Errorf("unexpected body: %s", body)
+	}
+
+	cancel()
+	if err := handle.Wait(); err != nil {
+		t.Error(err)
+	}
+	// the shared store is notified after both of its dependents
+	expected := []string{"server.will", "admin.will", "ctrl.will", "store.will", "server.did", "admin.did", "ctrl.did", "store.did"}
+	if !reflect.DeepEqual(testLifecycleEvents, expected) {
+		t.Errorf("hooks called in wrong order: %v", testLifecycleEvents)
+	}
+}
+
+type TestTlsServer struct {
+	WebServer
+}
+
+func (s *TestTlsServer) GetWhoami(ctx WebContext) {
+	if cert := ctx.ClientCertificate(); cert != nil {
+		ctx.String(http.StatusOK, cert.Subject.CommonName)
+	} else {
+		ctx.String(http.StatusUnauthorized, "")
+	}
+}
+
+// go test ./ -v -run TestStartGinWithMutualTls
+func TestStartGinWithMutualTls(t *testing.T) {
+	clientCert, err := GenerateSelfSignedCertificate("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert.Leaf)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	config := NewWebConfig().SetAddress("localhost").SetPort(freePort(t)).SetRtMode(RtTest).
+		SetTls(func(t *TlsConfig) {
+			t.Enable().SetClientCAs(clientCAs)
+		})
+	handle, err := StartGin(ctx, &TestTlsServer{}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !handle.IsTls() {
+		t.Fatal("server should serve https")
+	}
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		InsecureSkipVerify: true, // self-signed server certificate
+		Certificates:       []tls.Certificate{clientCert},
+	}}}
+	resp, err := client.Get("https://" + handle.Addr() + "/whoami")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "dij-gin" {
+		t.Errorf("unexpected response: %d %s", resp.StatusCode, body)
+	}
+
+	noCertClient := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	if _, err := noCertClient.Get("https://" + handle.Addr() + "/whoami"); err == nil {
+		t.Error("request without client certificate should fail")
+	}
+
+	cancel()
+	if err := handle.Wait(); err != nil {
+		t.Error(err)
+	}
+}
+
+func freePort(t *testing.T) int {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+type TestConcurrencyServer struct {
+	WebServer
+}
+
+var testConcurrencyBlocker = make(chan struct{})
+
+func (s *TestConcurrencyServer) GetSlow(ctx struct {
+	WebContext `http:"slow,concurrency=1"`
+}) {
+	<-testConcurrencyBlocker
+	ctx.String(http.StatusOK, "done")
+}
+
+// go test ./ -v -run TestRouteConcurrency
+func TestRouteConcurrency(t *testing.T) {
+	engine, _, err := PrepareGin(&TestConcurrencyServer{}, NewWebConfig().SetRtMode(RtTest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := httptest.NewRecorder()
+	finished := make(chan struct{})
+	go func() {
+		engine.ServeHTTP(first, httptest.NewRequest(http.MethodGet, "/slow", nil))
+		close(finished)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	second := httptest.NewRecorder()
+	engine.ServeHTTP(second, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	if second.Code != http.StatusServiceUnavailable || second.Header().Get("Retry-After") == "" {
+		t.Errorf("saturated route should respond 503 with Retry-After, got %d", second.Code)
+	}
+
+	close(testConcurrencyBlocker)
+	<-finished
+	if first.Code != http.StatusOK {
+		t.Errorf("first request should succeed, got %d", first.Code)
+	}
+}
+
+// go test ./ -v -run TestLimitListener
+func TestLimitListener(t *testing.T) {
+	const maxConn = 2
+	inner, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := LimitListener(inner, maxConn)
+	accepted := make(chan net.Conn, maxConn+1)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- c
+		}
+	}()
+	for i := 0; i < maxConn+1; i++ {
+		c, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+	}
+	var conns []net.Conn
+	for i := 0; i < maxConn; i++ {
+		select {
+		case c := <-accepted:
+			conns = append(conns, c)
+		case <-time.After(time.Second):
+			t.Fatalf("connection %d should be accepted", i+1)
+		}
+	}
+	select {
+	case <-accepted:
+		t.Fatalf("connection over MaxConn(%d) should wait", maxConn)
+	case <-time.After(100 * time.Millisecond):
+	}
+	// the waiting connection is accepted after an accepted one is closed
+	_ = conns[0].Close()
+	select {
+	case c := <-accepted:
+		_ = c.Close()
+	case <-time.After(time.Second):
+		t.Fatal("waiting connection should be accepted after a connection is closed")
+	}
+	_ = conns[1].Close()
+	_ = ln.Close()
+	if _, ok := <-accepted; ok {
+		t.Error("Accept should fail after the listener is closed")
+	}
+}
+
+type TestMisconfiguredServer struct {
+	WebServer
+}
+
+func (s *TestMisconfiguredServer) GetBadIn(ctx struct {
+	WebContext
+	Id int `http:"id,in=somewhere"`
+}) {
+}
+
+func (s *TestMisconfiguredServer) GetBadConcurrency(ctx struct {
+	WebContext `http:"bad_concurrency,concurrency=many"`
+}) {
+}
+
+func (s *TestMisconfiguredServer) GetBadMiddleware(ctx struct {
+	WebContext `http:"bad_middleware,middleware=nothing"`
+}) {
+}
+
+func (s *TestMisconfiguredServer) GetBadOptOut(ctx struct {
+	WebContext `http:"bad_opt_out,middleware=-nothing"`
+}) {
+}
+
+func (s *TestMisconfiguredServer) GetBadType(ctx struct {
+	WebContext `http:"bad_type"`
+	Callback   func() `http:"callback"`
+}) {
+}
+
+func (s *TestMisconfiguredServer) GetBadCoding(ctx struct {
+	WebContext `http:"bad_coding,json,urlenc"` // mixed codings, and GET has no body
+}) {
+}
+
+func (s *TestMisconfiguredServer) PostBadFile(ctx struct {
+	WebContext `http:"bad_file,json"`
+	File       *multipart.FileHeader `http:"file"`
+}) {
+}
+
+func (s *TestMisconfiguredServer) PostBadStructs(ctx struct {
+	WebContext `http:"bad_structs"`
+	Item       TestFailureItem
+	Note       *TestFailureNote // gin can't read the json body twice
+}) {
+}
+
+func (s *TestMisconfiguredServer) GetBadResult(ctx struct {
+	WebContext
+}) (result struct {
+	Data int
+}) {
+	return
+}
+
+// go test ./ -v -run TestSetupErrors
+func TestSetupErrors(t *testing.T) {
+	_, _, err := PrepareGin(&TestMisconfiguredServer{}, NewWebConfig().SetRtMode(RtTest))
+	var errs SetupErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("should return SetupErrors, but got: %v", err)
+	}
+	t.Log(errs)
+	// OpenAPI is disabled, all misconfigurations are still reported
+	if len(errs) != 10 {
+		t.Errorf("should collect 10 errors, but got %d", len(errs))
+	}
+	fields := map[string]string{}
+	for _, e := range errs {
+		if e.Controller != reflect.TypeOf(TestMisconfiguredServer{}) {
+			t.Errorf("unexpected controller: %v", e.Controller)
+		}
+		fields[e.Method] = e.Field
+	}
+	if _, exists := fields["PostBadStructs"]; !exists {
+		t.Error("the second struct field bound from json body should be reported")
+	}
+	if fields["GetBadIn"] != "Id" || fields["GetBadResult"] != "Data" || fields["GetBadType"] != "Callback" {
+		t.Errorf("unexpected fields: %v", fields)
+	}
+}
+
+// go test ./ -v -run TestRouteTable
+func TestRouteTable(t *testing.T) {
+	_, refPtr, err := PrepareGin(&TestWebServer{}, NewWebConfig().SetRtMode(RtTest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := GetRouteTable(refPtr)
+	if table == nil {
+		t.Fatal("route table should exist")
+	}
+	t.Log("\n" + table.String())
+
+	hello, ok := table.Find("get", "/hello")
+	if !ok {
+		t.Fatal("route GET /hello should exist")
+	}
+	if !reflect.DeepEqual(hello.Middlewares, []string{"abc", "cors", "efg"}) {
+		t.Errorf("unexpected middlewares: %v", hello.Middlewares)
+	}
+	if hello.Controller != "dij_gin_test.TestWebServer" || hello.Handler != "GetHello" {
+		t.Errorf("unexpected handler: %s.%s", hello.Controller, hello.Handler)
+	}
+
+	profile, ok := table.Find("GET", "/user/:id/profile")
+	if !ok {
+		t.Fatal("route GET /user/:id/profile should exist")
+	}
+	expected := []RouteParam{{Name: "id", In: InPathWay, Type: "int", Required: true}}
+	if !reflect.DeepEqual(profile.Params, expected) {
+		t.Errorf("unexpected params: %v", profile.Params)
+	}
+	if routes := table.RoutesOfController("dij_gin_test.TestWebController1"); len(routes) != 2 {
+		t.Errorf("controller should have 2 routes, but got %d", len(routes))
+	}
+}
+
+type testRecordLogger struct {
+	messages []string
+	fields   []map[string]any
+}
+
+func (l *testRecordLogger) Log(level LogLevel, msg string, fields ...LogField) {
+	l.messages = append(l.messages, level.String()+" "+msg)
+	m := map[string]any{}
+	for _, f := range fields {
+		m[f.Key] = f.Value
+	}
+	l.fields = append(l.fields, m)
+}
+
+// go test ./ -v -run TestWebLogger
+func TestWebLogger(t *testing.T) {
+	t.Run("custom", func(t *testing.T) {
+		logger := &testRecordLogger{}
+		if _, _, err := PrepareGin(&TestLifecycleServer{}, NewWebConfig().SetRtMode(RtTest).SetLogger(logger)); err != nil {
+			t.Fatal(err)
+		}
+		var extender, routes bool
+		for i, msg := range logger.messages {
+			if msg == "DEBUG setup extender" && logger.fields[i]["extender"] == reflect.TypeOf(&TestLifecycleController{}) {
+				extender = true
+			}
+			if strings.HasPrefix(msg, "INFO registered routes") && strings.Contains(msg, "/life/ping") {
+				routes = true
+			}
+		}
+		if !extender || !routes {
+			t.Errorf("missing logs: %v", logger.messages)
+		}
+	})
+	t.Run("silent in prod", func(t *testing.T) {
+		// logs are captured from stdout too, the default writer of NewWebConfig
+		stdout := os.Stdout
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Stdout = w
+		var buf strings.Builder
+		config := NewWebConfig().SetRtMode(RtProd).SetDefaultWriter(&buf) // set after the defaults are applied
+		_, refPtr, err := PrepareGin(&TestLifecycleServer{}, config)
+		if err == nil {
+			(*refPtr)[RefKeyForWebLogger].(WebLogger).Log(LogError, "probe")
+		}
+		os.Stdout = stdout
+		_ = w.Close()
+		out, _ := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if logs := buf.String() + string(out); strings.Contains(logs, "[dij-gin]") {
+			t.Errorf("should not log in prod: %s", logs)
+		}
+	})
+	t.Run("default from final config", func(t *testing.T) {
+		var buf strings.Builder
+		config := NewWebConfig().SetRtMode(RtTest).SetDefaultWriter(&buf)
+		_, refPtr, err := PrepareGin(&TestLifecycleServer{}, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.Logger != nil {
+			t.Error("default logger should not be written back into config")
+		}
+		(*refPtr)[RefKeyForWebLogger].(WebLogger).Log(LogInfo, "probe")
+		if !strings.Contains(buf.String(), "registered routes") || !strings.Contains(buf.String(), "probe") {
+			t.Errorf("logger should write to DefaultWriter of config: %s", buf.String())
+		}
+	})
+}
+
+type TestEngineServer struct {
+	WebServer
+}
+
+func (s *TestEngineServer) GetIp(ctx WebContext) {
+	ctx.String(http.StatusOK, ctx.ClientIP())
+}
+
+// go test ./ -v -run TestGinEngine
+func TestGinEngine(t *testing.T) {
+	clientIp := func(engine http.Handler, remoteAddr string) string {
+		req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", "1.2.3.4")
+		req.Header.Set("CF-Connecting-IP", "5.6.7.8")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+	t.Run("mode", func(t *testing.T) {
+		for env, mode := range map[RuntimeEnv]string{RtProd: gin.ReleaseMode, RtDev: gin.DebugMode, RtDebug: gin.DebugMode, RtTest: gin.TestMode} {
+			if _, _, err := PrepareGin(&TestEngineServer{}, NewWebConfig().SetRtMode(env).SetDefaultWriter(io.Discard)); err != nil {
+				t.Fatal(err)
+			}
+			if gin.Mode() != mode {
+				t.Errorf("gin mode of %s should be %s, but got %s", env, mode, gin.Mode())
+			}
+		}
+	})
+	t.Run("trusted proxies", func(t *testing.T) {
+		engine, _, err := PrepareGin(&TestEngineServer{}, NewWebConfig().SetRtMode(RtTest).SetEngine(func(e *EngineConfig) {
+			e.SetBaseMiddlewares().SetTrustedProxies("10.0.0.0/8")
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ip := clientIp(engine, "10.1.1.1:1234"); ip != "1.2.3.4" {
+			t.Errorf("client ip from trusted proxy should be 1.2.3.4, but got %s", ip)
+		}
+		if ip := clientIp(engine, "192.168.1.1:1234"); ip != "192.168.1.1" {
+			t.Errorf("client ip from untrusted proxy should be 192.168.1.1, but got %s", ip)
+		}
+		if len(engine.Handlers) != 0 {
+			t.Errorf("base middlewares should be empty, but got %d", len(engine.Handlers))
+		}
+	})
+	t.Run("trusted platform", func(t *testing.T) {
+		engine, _, err := PrepareGin(&TestEngineServer{}, NewWebConfig().SetRtMode(RtTest).SetEngine(func(e *EngineConfig) {
+			e.SetTrustedProxies().SetTrustedPlatform(gin.PlatformCloudflare)
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ip := clientIp(engine, "10.1.1.1:1234"); ip != "5.6.7.8" {
+			t.Errorf("client ip from trusted platform should be 5.6.7.8, but got %s", ip)
+		}
+	})
+	t.Run("incorrect proxies", func(t *testing.T) {
+		_, _, err := PrepareGin(&TestEngineServer{}, NewWebConfig().SetRtMode(RtTest).SetEngine(func(e *EngineConfig) {
+			e.SetTrustedProxies("not-an-ip")
+		}))
+		if err == nil {
+			t.Error("incorrect trusted proxies should be an error")
+		}
+	})
+}
+
+type TestEnvServer struct {
+	WebServer
+}
+
+func (s *TestEnvServer) GetNotProd(ctx struct {
+	WebContext `http:"not-prod,env=!prod"`
+}) {
+}
+
+func (s *TestEnvServer) GetStaging(ctx struct {
+	WebContext `http:"staging,env=staging"`
+}) {
+}
+
+func (s *TestEnvServer) GetDev(ctx struct {
+	WebContext `http:"dev,env=dev&test"`
+}) {
+}
+
+// go test ./ -v -run TestEnvExpr
+func TestEnvExpr(t *testing.T) {
+	for expr, expected := range map[string]bool{"": true, "prod": true, "dev&prod": true, "!prod": false, "dev&!prod": false, "!dev": true, "dev": false} {
+		if RtProd.IsInOnlyEnv(expr) != expected {
+			t.Errorf("prod in '%s' should be %v", expr, expected)
+		}
+	}
+
+	config := func(env RuntimeEnv) *WebConfig {
+		return NewWebConfig().SetRtMode(env).SetDefaultWriter(io.Discard).
+			SetEnv("staging", func(e *EnvConfig) {
+				e.SetBase(RtProd).SetPort(8080).SetOpenApiEnabled(true).SetDependentRef("stage", "staging")
+			}).
+			SetEnv("canary", func(e *EnvConfig) {
+				e.SetBase("staging").SetPort(8081)
+			})
+	}
+	paths := func(env RuntimeEnv) []string {
+		_, ref, err := PrepareGin(&TestEnvServer{}, config(env))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var paths []string
+		for _, r := range GetRouteTable(ref).Routes {
+			paths = append(paths, r.Path)
+		}
+		return paths
+	}
+	for env, expected := range map[RuntimeEnv][]string{
+		RtProd:    nil,
+		RtDev:     {"/dev", "/not-prod"},
+		"staging": {"/staging"},
+		"canary":  {"/staging"},
+	} {
+		if p := paths(env); !reflect.DeepEqual(p, expected) {
+			t.Errorf("routes in %s should be %v, but got %v", env, expected, p)
+		}
+	}
+
+	c := config("canary")
+	c.ApplyDefaultValues()
+	if !reflect.DeepEqual(c.EnvChain(), []RuntimeEnv{"canary", "staging", RtProd}) || c.BaseEnv() != RtProd {
+		t.Errorf("incorrect env chain: %v", c.EnvChain())
+	}
+	if c.Port != 8081 || !c.OpenApi.Enabled || c.DependentRefs["stage"] != "staging" {
+		t.Errorf("overlays are not applied: %d %v %v", c.Port, c.OpenApi.Enabled, c.DependentRefs)
+	}
+
+	_, _, err := PrepareGin(&TestEnvServer{}, NewWebConfig().SetRtMode(RtTest))
+	var setupErrs SetupErrors
+	if !errors.As(err, &setupErrs) || len(setupErrs) != 1 || !strings.Contains(setupErrs[0].Error(), "staging") {
+		t.Errorf("unknown environment should be a setup error, but got %v", err)
+	}
+}
+
+type TestDbConfig struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+// go test ./ -v -run TestConfigLoader
+func TestConfigLoader(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	yamlFile := write("base.yaml", `
+port: 8080
+max_conn: 100
+rtEnv: staging
+shutdownTimeout: 3s
+openApi:
+  enabled: false
+  schemes: [http]
+envs:
+  staging:
+    base: prod
+    openApiEnabled: true
+database:
+  host: db.local
+  port: 5432
+`)
+	jsonFile := write("override.json", `{"basePath": "api", "tls": {"minVersion": "1.3"}}`)
+	tomlFile := write("override.toml", "address = \"0.0.0.0\"\n[openApi]\ntitle = \"toml\"\n")
+	env := map[string]string{"DIJGIN_PORT": "9090", "DIJGIN_OPENAPI_TITLE": "env", "DIJGIN_ENGINE_TRUSTED_PROXIES": "10.0.0.0/8, 127.0.0.1"}
+	lookup := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+
+	db := &TestDbConfig{}
+	config, err := NewConfigLoader(yamlFile, jsonFile, tomlFile).SetLookupEnv(lookup).SetSection("database", db).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Port != 9090 || config.MaxConn != 100 || config.BasePath != "api" || config.Address != "0.0.0.0" ||
+		config.ShutdownTimeout != 3*time.Second || config.Tls.MinVersion != tls.VersionTLS13 {
+		t.Errorf("incorrect config: %+v", config)
+	}
+	if !config.OpenApi.Enabled || config.OpenApi.Title != "env" || !reflect.DeepEqual(config.OpenApi.Schemes, []string{"http"}) {
+		t.Errorf("incorrect openapi config: %+v", config.OpenApi)
+	}
+	if config.BaseEnv() != RtProd || !reflect.DeepEqual(config.Engine.TrustedProxies, []string{"10.0.0.0/8", "127.0.0.1"}) {
+		t.Errorf("incorrect env or engine config: %v %+v", config.BaseEnv(), config.Engine)
+	}
+	if db.Host != "db.local" || db.Port != 5432 || config.DependentRefs["database"] != db {
+		t.Errorf("incorrect section: %+v", db)
+	}
+
+	// versions are numbers in files
+	for name, c := range map[string]struct {
+		content  string
+		expected uint16
+	}{
+		"version.yaml": {"tls:\n  minVersion: 1.0\n", tls.VersionTLS10},
+		"version.json": {`{"tls": {"minVersion": 1.2}}`, tls.VersionTLS12},
+		"version.toml": {"[tls]\nminVersion = 1.2\n", tls.VersionTLS12},
+	} {
+		versionConfig, err := NewConfigLoader(write(name, c.content)).SetLookupEnv(func(string) (string, bool) { return "", false }).Load()
+		if err != nil {
+			t.Errorf("tls version of %s should be loaded: %v", name, err)
+		} else if versionConfig.Tls.MinVersion != c.expected {
+			t.Errorf("tls version of %s should be %x, but got %x", name, c.expected, versionConfig.Tls.MinVersion)
+		}
+	}
+
+	badFile := write("bad.yaml", "prot: 8080\nopenApi:\n  port: abc\ndatabase:\n  hots: x\nrt_env: nowhere\n")
+	env = map[string]string{"DIJGIN_MAX_CONN": "-1"}
+	_, err = NewConfigLoader(badFile).SetLookupEnv(lookup).SetSection("database", &TestDbConfig{}).Load()
+	var configErrs ConfigErrors
+	if !errors.As(err, &configErrs) {
+		t.Fatalf("should be config errors, but got %v", err)
+	}
+	var keys []string
+	for _, e := range configErrs {
+		keys = append(keys, e.Key)
+	}
+	// incorrect values are reported by the environment variables or the keys in files which set them
+	if !reflect.DeepEqual(keys, []string{"database", "openApi.port", "prot", "DIJGIN_MAX_CONN", "rt_env"}) ||
+		configErrs[3].Source != "env" || configErrs[4].Source != badFile {
+		t.Errorf("incorrect error keys: %v\n%v", keys, err)
+	}
+}
+
+type TestFlagServer struct {
+	WebServer
+
+	ctrl  *TestFlagController         `di:"^"`
+	flags *libs.FeatureFlagController `di:"^"`
+}
+
+func (s *TestFlagServer) GetBeta(ctx struct {
+	WebContext `http:"beta,flag=beta"`
+}) {
+	ctx.String(http.StatusOK, "beta")
+}
+
+type TestFlagController struct {
+	WebController `http:"v2,flag=v2"`
+}
+
+func (c *TestFlagController) GetPing(ctx struct {
+	WebContext `http:"ping,flag=beta"`
+}) {
+	ctx.String(http.StatusOK, "pong")
+}
+
+// go test ./ -v -run TestFeatureFlags
+func TestFeatureFlags(t *testing.T) {
+	flags := NewMemoryFeatureFlags(map[string]bool{"v2": true})
+	engine, ref, err := PrepareGin(&TestFlagServer{}, NewWebConfig().SetRtMode(RtTest).SetFeatureFlagProvider(flags).SetFlagOffStatus(http.StatusForbidden))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(method, path, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := serve(http.MethodGet, "/beta", ""); code != http.StatusForbidden {
+		t.Errorf("beta is off, status should be 403, but got %d", code)
+	}
+	if code := serve(http.MethodPut, "/flags/beta", `{"enabled":true}`); code != http.StatusOK || !flags.IsEnabled(nil, "beta") {
+		t.Errorf("flip flag failed: %d", code)
+	}
+	if code := serve(http.MethodGet, "/beta", ""); code != http.StatusOK {
+		t.Errorf("beta is on, status should be 200, but got %d", code)
+	}
+	if code := serve(http.MethodGet, "/v2/ping", ""); code != http.StatusOK {
+		t.Errorf("v2 and beta are on, status should be 200, but got %d", code)
+	}
+	flags.SetFlag("v2", false)
+	if code := serve(http.MethodGet, "/v2/ping", ""); code != http.StatusForbidden {
+		t.Errorf("v2 is off, status should be 403, but got %d", code)
+	}
+	if r, ok := GetRouteTable(ref).Find(http.MethodGet, "/v2/ping"); !ok || !reflect.DeepEqual(r.Flags, []string{"beta", "v2"}) {
+		t.Errorf("incorrect flags in route table: %v", r.Flags)
+	}
+
+	_, _, err = PrepareGin(&TestFlagServer{}, NewWebConfig().SetRtMode(RtTest))
+	var setupErrs SetupErrors
+	if !errors.As(err, &setupErrs) || len(setupErrs) != 2 {
+		t.Errorf("flags without provider should be setup errors, but got %v", err)
+	}
+}
+
+type TestStaticServer struct {
+	WebServer
+
+	assets *TestAssets    `di:"^"`
+	files  *TestDirAssets `di:"^"`
+}
+
+type TestAssets struct {
+	libs.StaticController `http:"assets,ref=testAssets,max-age=60"`
+}
+
+type TestDirAssets struct {
+	libs.StaticController `http:"files,ref=testFiles,listing"`
+}
+
+// go test ./ -v -run TestStaticController
+func TestStaticController(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"a.txt": "aaa", "sub/b.txt": "bbb", ".secret": "x"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assets := fstest.MapFS{
+		"dist/index.html": {Data: []byte("<h1>index</h1>")},
+		"dist/app.js":     {Data: []byte("console.log(1)")},
+	}
+	config := NewWebConfig().SetRtMode(RtTest).
+		SetDependentRef("testAssets", &libs.StaticConfig{FS: assets, Root: "dist"}).
+		SetDependentRef("testFiles", &libs.StaticConfig{Dir: dir})
+	engine, ref, err := PrepareGin(&TestStaticServer{}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(path string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("/assets/app.js")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || w.Body.String() != "console.log(1)" || len(etag) == 0 || w.Header().Get("Cache-Control") != "public, max-age=60" {
+		t.Errorf("incorrect response: %d %v %s", w.Code, w.Header(), w.Body.String())
+	}
+	if w = serve("/assets/app.js", "If-None-Match", etag); w.Code != http.StatusNotModified {
+		t.Errorf("status should be 304, but got %d", w.Code)
+	}
+	if w = serve("/assets/"); w.Code != http.StatusOK || w.Body.String() != "<h1>index</h1>" {
+		t.Errorf("index file should be served: %d %s", w.Code, w.Body.String())
+	}
+
+	w = serve("/files/a.txt")
+	if w.Code != http.StatusOK || w.Body.String() != "aaa" || len(w.Header().Get("Last-Modified")) == 0 || w.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("incorrect response: %d %v %s", w.Code, w.Header(), w.Body.String())
+	}
+	if w = serve("/files/a.txt", "If-Modified-Since", w.Header().Get("Last-Modified")); w.Code != http.StatusNotModified {
+		t.Errorf("status should be 304, but got %d", w.Code)
+	}
+	if w = serve("/files/.secret"); w.Code != http.StatusNotFound {
+		t.Errorf("hidden file should not be served: %d", w.Code)
+	}
+	if w = serve("/files/../weblauncher_test.go"); w.Code == http.StatusOK {
+		t.Errorf("file out of dir should not be served")
+	}
+	if w = serve("/files/sub"); w.Code != http.StatusMovedPermanently {
+		t.Errorf("directory without trailing slash should be redirected: %d", w.Code)
+	}
+	if w = serve("/files/"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `<a href="sub/">sub/</a>`) || strings.Contains(w.Body.String(), "secret") {
+		t.Errorf("incorrect listing: %d %s", w.Code, w.Body.String())
+	}
+	if _, ok := GetRouteTable(ref).Find(http.MethodGet, "/assets/*filepath"); !ok {
+		t.Error("static route should be in route table")
+	}
+
+	config.SetDependentRef("testFiles", &libs.StaticConfig{Dir: filepath.Join(dir, "none")})
+	if _, _, err = PrepareGin(&TestStaticServer{}, config); err == nil {
+		t.Error("incorrect dir should be a setup error")
+	}
+}
+
+type TestSpaServer struct {
+	WebServer
+
+	frontend *TestFrontend `di:"^"`
+}
+
+func (s *TestSpaServer) GetApiPing(ctx struct {
+	WebContext `http:"api/ping"`
+}) {
+	ctx.String(http.StatusOK, "pong")
+}
+
+func (s *TestSpaServer) NoRoute(ctx WebContext) {
+	ctx.JSON(http.StatusNotFound, ToWebError(errors.New("no route"), "no_route"))
+}
+
+type TestFrontend struct {
+	libs.SpaController `http:",ref=spa,exclude=/api"`
+}
+
+// go test ./ -v -run TestSpaController
+func TestSpaController(t *testing.T) {
+	build := fstest.MapFS{
+		"dist/index.html":              {Data: []byte("<html><head><title>app</title></head><body></body></html>")},
+		"dist/assets/app.3f2a1b9c.js":  {Data: []byte("console.log(1)")},
+		"dist/assets/logo.png":         {Data: []byte("png")},
+		"dist/assets/my-component.css": {Data: []byte("body{}")},
+	}
+	config := NewWebConfig().SetRtMode(RtTest).SetBasePath("v1").
+		SetDependentRef("spa", &libs.SpaConfig{FS: build, Root: "dist", RuntimeConfig: map[string]any{"title": "<app>"}})
+	engine, _, err := PrepareGin(&TestSpaServer{}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(method, path, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if len(accept) > 0 {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+	const html = "text/html,application/xhtml+xml"
+
+	for _, path := range []string{"/", "/users/123", "/index.html"} {
+		w := serve(http.MethodGet, path, html)
+		if w.Code != http.StatusOK || w.Header().Get("Cache-Control") != "no-cache" ||
+			!strings.Contains(w.Body.String(), `<script>window.__DIJGIN_CONFIG__={"basePath":"/v1","title":"\u003capp\u003e"}</script></head>`) {
+			t.Errorf("%s should fall back to index: %d %s", path, w.Code, w.Body.String())
+		}
+	}
+	if w := serve(http.MethodGet, "/assets/app.3f2a1b9c.js", ""); w.Code != http.StatusOK || w.Header().Get("Cache-Control") != libs.ImmutableCacheControl {
+		t.Errorf("hashed asset should be immutable: %d %v", w.Code, w.Header())
+	}
+	if w := serve(http.MethodGet, "/assets/my-component.css", ""); w.Code != http.StatusOK || w.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("unhashed asset should not be immutable: %d %v", w.Code, w.Header())
+	}
+	if w := serve(http.MethodGet, "/api/ping", html); w.Body.String() != "pong" {
+		t.Errorf("api route should work: %s", w.Body.String())
+	}
+	for _, c := range []struct{ method, path, accept string }{
+		{http.MethodGet, "/api/none", html},
+		{http.MethodGet, "/assets/none.js", html},
+		{http.MethodGet, "/users/123", "application/json"},
+		{http.MethodPost, "/users/123", html},
+	} {
+		if w := serve(c.method, c.path, c.accept); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "no_route") {
+			t.Errorf("%s %s should be passed to NoRoute: %d %s", c.method, c.path, w.Code, w.Body.String())
+		}
+	}
+	if !libs.IsHashedAsset("index-B2x9kQ7a.css") || libs.IsHashedAsset("component.js") {
+		t.Error("incorrect hashed asset detection")
+	}
+}
+
+type TestTemplateServer struct {
+	WebServer
+}
+
+type TestProfile struct {
+	Name string
+	Bio  string
+}
+
+func (s *TestTemplateServer) GetProfile(ctx struct {
+	WebContext `http:"profile"`
+	Name       string `http:"name"`
+}) (result struct {
+	Page  *TestProfile `http:"200,html,template=user/profile"`
+	Plain *string      `http:"201,template=plain,layout=-"`
+}) {
+	if ctx.Name == "plain" {
+		result.Plain = &ctx.Name
+	} else {
+		result.Page = &TestProfile{Name: ctx.Name, Bio: "gopher"}
+	}
+	return
+}
+
+type TestBadTemplateServer struct {
+	WebServer
+}
+
+func (s *TestBadTemplateServer) GetProfile(ctx struct {
+	WebContext `http:"profile"`
+}) (result struct {
+	Page *TestProfile `http:"200,template=user/none"`
+}) {
+	return
+}
+
+// go test ./ -v -run TestHtmlTemplate
+func TestHtmlTemplate(t *testing.T) {
+	templates := fstest.MapFS{
+		"views/layouts/base.html":  {Data: []byte(`<title>{{block "title" .}}site{{end}}</title>{{template "partials/nav" .}}{{block "content" .}}{{end}}`)},
+		"views/partials/nav.html":  {Data: []byte(`<nav>{{upper .Name}}</nav>`)},
+		"views/user/profile.html":  {Data: []byte(`{{define "title"}}{{.Name}}{{end}}{{define "content"}}<p>{{.Bio}}</p>{{end}}`)},
+		"views/plain.html":         {Data: []byte(`<p>{{.}}</p>`)},
+		"views/layouts/readme.txt": {Data: []byte(`not a template`)},
+	}
+	config := NewWebConfig().SetRtMode(RtTest).SetTemplate(func(t *TemplateConfig) {
+		t.SetFS(templates, "views").SetLayout("base").SetFunc("upper", strings.ToUpper)
+	})
+	engine, _, err := PrepareGin(&TestTemplateServer{}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(engine http.Handler, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+	if w := get(engine, "/profile?name=%3Cb%3Eyu"); w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/html; charset=utf-8" ||
+		w.Body.String() != `<title>&lt;b&gt;yu</title><nav>&lt;B&gt;YU</nav><p>gopher</p>` {
+		t.Errorf("incorrect page: %d %s", w.Code, w.Body.String())
+	}
+	if w := get(engine, "/profile?name=plain"); w.Code != http.StatusCreated || w.Body.String() != `<p>plain</p>` {
+		t.Errorf("incorrect page without layout: %d %s", w.Code, w.Body.String())
+	}
+
+	// misconfigurations are found in setup
+	if _, _, err := PrepareGin(&TestBadTemplateServer{}, NewWebConfig().SetRtMode(RtTest)); err == nil || !strings.Contains(err.Error(), "needs WebConfig.Template") {
+		t.Errorf("should fail without templates: %v", err)
+	}
+	config = NewWebConfig().SetRtMode(RtTest).SetTemplate(func(t *TemplateConfig) { t.SetFS(templates, "views").SetFunc("upper", strings.ToUpper) })
+	if _, _, err := PrepareGin(&TestBadTemplateServer{}, config); err == nil || !strings.Contains(err.Error(), "template(user/none) doesn't exist") {
+		t.Errorf("should fail with missing template: %v", err)
+	}
+
+	// templates are reloaded in dev runtime environment
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "user"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "user", "profile.html"), []byte(`{{.Name}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "plain.html"), []byte(`v1 {{.}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config = NewWebConfig().SetRtMode(RtDev).SetTemplate(func(t *TemplateConfig) { t.SetDir(dir) })
+	engine, _, err = PrepareGin(&TestTemplateServer{}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := get(engine, "/profile?name=plain"); w.Body.String() != `v1 plain` {
+		t.Errorf("incorrect page: %s", w.Body.String())
+	}
+	if err := os.WriteFile(filepath.Join(dir, "plain.html"), []byte(`v2 {{.}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if w := get(engine, "/profile?name=plain"); w.Body.String() != `v2 plain` {
+		t.Errorf("template should be reloaded: %s", w.Body.String())
+	}
+}
+
+type TestMountServer struct {
+	WebServer
+
+	avatars     *TestFileController  `di:"" http:"avatars,tag=Avatars,middleware=stamp"`
+	attachments *TestFileController  `di:""` // mounted by WebConfig.SetMount
+	files       *TestFileController  `di:""`
+	_           *TestStampMiddleware `di:"^"`
+}
+
+type TestStampMiddleware struct {
+	WebMiddleware
+}
+
+func (m *TestStampMiddleware) HandleStamp(ctx WebContext) {
+	ctx.Header("X-Stamp", "yes")
+}
+
+type TestFileController struct {
+	WebController `http:"files,tag=Files"`
+
+	dir string `di:"file.dir"`
+}
+
+func (c *TestFileController) GetFile(ctx struct {
+	WebContext `http:":name"`
+	Name       string `http:"name,in=path"`
+}) {
+	ctx.String(http.StatusOK, c.dir+"/"+ctx.Name)
+}
+
+// go test ./ -v -run TestMountController
+func TestMountController(t *testing.T) {
+	config := NewWebConfig().SetRtMode(RtTest).SetDependentRef("file.dir", "/data").
+		SetOpenApi(func(o *OpenApiConfig) { o.Enable() }).
+		SetMount("attachments", func(m *MountConfig) {
+			m.SetPath("attachments").SetTag("Attachments").SetDependentRef("file.dir", "/mnt/attachments")
+		})
+	engine, refPtr, err := PrepareGin(&TestMountServer{}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, expected := range map[string]string{
+		"/avatars/a.png":     "/data/a.png",
+		"/attachments/b.pdf": "/mnt/attachments/b.pdf",
+		"/files/c.txt":       "/data/c.txt",
+	} {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Body.String() != expected {
+			t.Errorf("%s should be %s, but got %s", path, expected, w.Body.String())
+		}
+		if stamped := w.Header().Get("X-Stamp") == "yes"; stamped != strings.HasPrefix(path, "/avatars") {
+			t.Errorf("incorrect middleware of %s", path)
+		}
+	}
+
+	table := GetRouteTable(refPtr)
+	if r, ok := table.Find(http.MethodGet, "/avatars/:name"); !ok || r.Mount != "avatars" || !reflect.DeepEqual(r.Middlewares, []string{"stamp"}) {
+		t.Errorf("incorrect route: %+v", r)
+	}
+	openapi := (*refPtr)[RefKeyForWebSpecRecord].(*spec.Openapi)
+	for path, expected := range map[string][2]string{
+		"/avatars/{name}":     {"avatarsGetFile", "Avatars"},
+		"/attachments/{name}": {"attachmentsGetFile", "Attachments"},
+		"/files/{name}":       {"getFile", "Files"}, // not a mount
+	} {
+		if op := openapi.Paths[path].Get; op == nil || op.OperationID != expected[0] || !reflect.DeepEqual(op.Tags, []string{expected[1]}) {
+			t.Errorf("incorrect operation of %s: %+v", path, op)
+		}
+	}
+
+	_, _, err = PrepareGin(&TestSharedMountServer{}, NewWebConfig().SetRtMode(RtTest))
+	var errs SetupErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Field != "attachments" {
+		t.Errorf("shared instance of mounts should be a setup error, but got %v", err)
+	}
+}
+
+type TestSharedMountServer struct {
+	WebServer
+
+	avatars     *TestFileController `di:"^" http:"avatars"`
+	attachments *TestFileController `di:"^" http:"attachments"` // the same instance as avatars
+}
+
+type TestDynamicServer struct {
+	WebServer
+
+	_ *libs.SwaggerController `di:"^"` // the document is served while controllers are attached and detached
+}
+
+func (s *TestDynamicServer) GetPing(ctx WebContext) {
+	ctx.String(http.StatusOK, "pong")
+}
+
+func (s *TestDynamicServer) NoRoute(ctx WebContext) {
+	ctx.String(http.StatusNotFound, "no route")
+}
+
+type TestPluginController struct {
+	WebController `http:"plugins/acme,tag=Acme"`
+
+	greeting string `di:"greeting"`
+}
+
+func (c *TestPluginController) GetHello(ctx struct {
+	WebContext `http:"hello/:name"`
+	Name       string `http:"name,in=path"`
+}) {
+	ctx.String(http.StatusOK, c.greeting+" "+ctx.Name)
+}
+
+type TestConflictPluginController struct {
+	WebController
+}
+
+func (c *TestConflictPluginController) GetPing(ctx WebContext) {
+	ctx.String(http.StatusOK, "conflict")
+}
+
+// go test ./ -v -run TestDynamicRoutes
+func TestDynamicRoutes(t *testing.T) {
+	config := NewWebConfig().SetRtMode(RtTest).SetDependentRef("greeting", "hi").
+		SetOpenApi(func(o *OpenApiConfig) { o.Enable() })
+	engine, refPtr, err := PrepareGin(&TestDynamicServer{}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+	dynamic := GetDynamicRoutes(refPtr)
+	const pluginPath = "/plugins/acme/hello/{name}"
+
+	if w := get("/plugins/acme/hello/yu"); w.Code != http.StatusNotFound || w.Body.String() != "no route" {
+		t.Errorf("route shouldn't exist before attaching: %d %s", w.Code, w.Body.String())
+	}
+	if err := dynamic.Attach("acme", reflect.TypeOf(TestPluginController{})); err != nil {
+		t.Fatal(err)
+	}
+	if w := get("/plugins/acme/hello/yu"); w.Code != http.StatusOK || w.Body.String() != "hi yu" {
+		t.Errorf("incorrect dynamic route: %d %s", w.Code, w.Body.String())
+	}
+	if _, exists := dynamic.Openapi().Paths[pluginPath]; !exists {
+		t.Errorf("document should contain %s", pluginPath)
+	}
+	if _, exists := (*refPtr)[RefKeyForWebSpecRecord].(*spec.Openapi).Paths[pluginPath]; exists {
+		t.Errorf("document of static routes shouldn't contain %s", pluginPath)
+	}
+	if routes := dynamic.Routes(); len(routes) != 1 || routes[0].Dynamic != "acme" {
+		t.Errorf("incorrect dynamic routes: %+v", routes)
+	}
+	if err := dynamic.Attach("acme", &TestPluginController{}); err == nil {
+		t.Error("attaching a duplicated name should fail")
+	}
+	if err := dynamic.Attach("conflict", &TestConflictPluginController{}); err == nil || !strings.Contains(err.Error(), "conflicts with a static route") {
+		t.Errorf("attaching a conflicting route should fail: %v", err)
+	}
+	if names := dynamic.Names(); !reflect.DeepEqual(names, []string{"acme"}) {
+		t.Errorf("incorrect names: %v", names)
+	}
+
+	// requests are served while controllers are attached and detached, go test -race checks the document.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if w := get("/ping"); w.Body.String() != "pong" {
+					t.Errorf("static route is broken: %s", w.Body.String())
+				}
+				if w := get("/plugins/acme/hello/yu"); w.Code != http.StatusOK && w.Code != http.StatusNotFound {
+					t.Errorf("unexpected status: %d", w.Code)
+				}
+				if w := get("/doc/swagger.json"); w.Code != http.StatusOK {
+					t.Errorf("document isn't served: %d", w.Code)
+				}
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		if err := dynamic.Detach("acme"); err != nil {
+			t.Error(err)
+		}
+		if err := dynamic.Attach("acme", reflect.TypeOf(TestPluginController{})); err != nil {
+			t.Error(err)
+		}
+	}
+	wg.Wait()
+
+	if err := dynamic.Detach("acme"); err != nil {
+		t.Fatal(err)
+	}
+	if w := get("/plugins/acme/hello/yu"); w.Code != http.StatusNotFound || w.Body.String() != "no route" {
+		t.Errorf("route should be removed: %d %s", w.Code, w.Body.String())
+	}
+	if doc := dynamic.Openapi(); doc.Paths[pluginPath].Get != nil || doc.Paths["/ping"].Get == nil {
+		t.Errorf("incorrect document after detaching: %v", doc.Paths)
+	}
+	if err := dynamic.Detach("acme"); err == nil {
+		t.Error("detaching an unknown name should fail")
+	}
+}
+
+type TestChainMiddleware struct {
+	WebMiddleware
+}
+
+func (m *TestChainMiddleware) HandleA(ctx WebContext) {
+	ctx.Writer.Header().Add("X-Chain", "a")
+}
+
+func (m *TestChainMiddleware) HandleB(ctx WebContext) {
+	ctx.Writer.Header().Add("X-Chain", "b")
+}
+
+func (m *TestChainMiddleware) HandleC(ctx WebContext) {
+	ctx.Writer.Header().Add("X-Chain", "c")
+}
+
+type TestInheritServer struct {
+	WebServer `http:",middleware=a&b,inherit"`
+
+	_     *TestChainMiddleware   `di:"^"`
+	child *TestChildController   `di:"^"`
+	local *TestLocalMwController `di:"^"`
+}
+
+func (s *TestInheritServer) GetRoot(ctx struct {
+	WebContext `http:"root"`
+}) {
+	ctx.Status(http.StatusNoContent)
+}
+
+type TestChildController struct {
+	WebController `http:"child,middleware=-b"`
+}
+
+func (c *TestChildController) GetPing(ctx WebContext) {
+	ctx.Status(http.StatusNoContent)
+}
+
+func (c *TestChildController) GetRaw(ctx struct {
+	WebContext `http:"raw,middleware=-a"`
+}) {
+	ctx.Status(http.StatusNoContent)
+}
+
+type TestLocalMwController struct {
+	WebController `http:"local,middleware=c"`
+
+	_    *TestChainMiddleware `di:"^"`
+	leaf *TestLeafController  `di:"^"`
+}
+
+func (c *TestLocalMwController) GetPing(ctx WebContext) {
+	ctx.Status(http.StatusNoContent)
+}
+
+type TestLeafController struct {
+	WebController `http:"leaf"`
+}
+
+func (c *TestLeafController) GetPing(ctx WebContext) {
+	ctx.Status(http.StatusNoContent)
+}
+
+// go test ./ -v -run TestMiddlewareInheritance
+func TestMiddlewareInheritance(t *testing.T) {
+	logger := &testRecordLogger{}
+	engine, refPtr, err := PrepareGin(&TestInheritServer{}, NewWebConfig().SetRtMode(RtTest).SetLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var warned []any
+	for i, msg := range logger.messages {
+		if msg == "WARN middlewares don't apply to extenders without inherit attribute" {
+			warned = append(warned, logger.fields[i]["controller"])
+		}
+	}
+	if fmt.Sprint(warned) != fmt.Sprint([]any{reflect.TypeOf(TestLocalMwController{})}) {
+		t.Errorf("only TestLocalMwController should be warned, but got %v", warned)
+	}
+	table := GetRouteTable(refPtr)
+	for path, expected := range map[string][]string{
+		"/root":            {"a", "b"},
+		"/child/ping":      {"a"},
+		"/child/raw":       nil,
+		"/local/ping":      {"a", "b", "c"},
+		"/local/leaf/ping": {"a", "b"}, // c isn't inherited
+	} {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if chain := w.Header().Values("X-Chain"); fmt.Sprint(chain) != fmt.Sprint(expected) {
+			t.Errorf("chain of %s should be %v, but got %v", path, expected, chain)
+		}
+		if r, _ := table.Find(http.MethodGet, path); fmt.Sprint(r.Middlewares) != fmt.Sprint(expected) {
+			t.Errorf("middlewares of %s in route table should be %v, but got %v", path, expected, r.Middlewares)
+		}
+	}
+}
+
+type TestRate struct {
+	N   int    `validate:"min=1"`
+	Per string `validate:"oneof=s m"`
+}
+
+func (r *TestRate) ParseMiddlewareArgs(args string) error {
+	_, err := fmt.Sscanf(strings.Replace(args, "/", " ", 1), "%d %s", &r.N, &r.Per)
+	return err
+}
+
+type TestParamMiddleware struct {
+	WebMiddleware
+}
+
+func (m *TestParamMiddleware) HandleRatelimit(ctx struct {
+	WebContext
+	Rate TestRate `http:",options"`
+}) {
+	ctx.Header("X-Rate", fmt.Sprintf("%d/%s", ctx.Rate.N, ctx.Rate.Per))
+}
+
+func (m *TestParamMiddleware) HandleRole(ctx struct {
+	WebContext
+	Role string `http:",options" validate:"oneof=admin editor"`
+}) {
+	ctx.Header("X-Role", ctx.Role)
+}
+
+func (m *TestParamMiddleware) HandleStamp(ctx WebContext) {
+	ctx.Header("X-Stamp", "yes")
+}
+
+type TestParamServer struct {
+	WebServer `http:",middleware=ratelimit(5/s),inherit"`
+
+	_ *TestParamMiddleware `di:"^"`
+}
+
+func (s *TestParamServer) GetDefault(ctx WebContext) {
+	ctx.Status(http.StatusNoContent)
+}
+
+func (s *TestParamServer) GetAdmin(ctx struct {
+	WebContext `http:"admin,middleware=ratelimit(100/s)&role(admin)"`
+}) {
+	ctx.Status(http.StatusNoContent)
+}
+
+type TestBadParamServer struct {
+	WebServer
+
+	_ *TestParamMiddleware `di:"^"`
+}
+
+func (s *TestBadParamServer) GetBadRole(ctx struct {
+	WebContext `http:"bad_role,middleware=role(guest)"`
+}) {
+}
+
+func (s *TestBadParamServer) GetBadRate(ctx struct {
+	WebContext `http:"bad_rate,middleware=ratelimit(0/s)"`
+}) {
+}
+
+func (s *TestBadParamServer) GetBadStamp(ctx struct {
+	WebContext `http:"bad_stamp,middleware=stamp(yes)"`
+}) {
+}
+
+func (s *TestBadParamServer) GetNoRole(ctx struct {
+	WebContext `http:"no_role,middleware=role"`
+}) {
+}
+
+// go test ./ -v -run TestParameterizedMiddleware
+func TestParameterizedMiddleware(t *testing.T) {
+	engine, refPtr, err := PrepareGin(&TestParamServer{}, NewWebConfig().SetRtMode(RtTest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, expected := range map[string][2]string{
+		"/default": {"5/s", ""},
+		"/admin":   {"100/s", "admin"},
+	} {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if rate, role := w.Header().Get("X-Rate"), w.Header().Get("X-Role"); rate != expected[0] || role != expected[1] {
+			t.Errorf("%s should get rate %s and role %s, but got %s and %s", path, expected[0], expected[1], rate, role)
+		}
+	}
+	if r, _ := GetRouteTable(refPtr).Find(http.MethodGet, "/admin"); !reflect.DeepEqual(r.Middlewares, []string{"ratelimit(100/s)", "role(admin)"}) {
+		t.Errorf("incorrect middlewares in route table: %v", r.Middlewares)
+	}
+
+	_, _, err = PrepareGin(&TestBadParamServer{}, NewWebConfig().SetRtMode(RtTest))
+	var errs SetupErrors
+	if !errors.As(err, &errs) || len(errs) != 4 {
+		t.Fatalf("should collect 4 errors, but got: %v", err)
+	}
+	t.Log(errs)
+}
+
+type TestRegistryServer struct {
+	WebServer
+
+	_     *TestChainMiddleware    `di:"^"`
+	child *TestRegistryController `di:"^"`
+}
+
+type TestRegistryController struct {
+	WebController `http:"child,middleware=a&trace"` // a is declared by TestRegistryServer, trace by WebConfig
+}
+
+func (c *TestRegistryController) GetPing(ctx WebContext) {
+	ctx.Status(http.StatusNoContent)
+}
+
+// go test ./ -v -run TestMiddlewareRegistry
+func TestMiddlewareRegistry(t *testing.T) {
+	config := NewWebConfig().SetRtMode(RtTest).
+		SetMiddleware("trace", func(c *gin.Context) { c.Writer.Header().Add("X-Chain", "trace") }).
+		SetMiddleware("global", func(c *gin.Context) { c.Writer.Header().Add("X-Chain", "global") }).
+		SetGlobalMiddlewares("global")
+	engine, refPtr, err := PrepareGin(&TestRegistryServer{}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, expected := range map[string][]string{
+		"/child/ping": {"global", "a", "trace"},
+		"/nowhere":    {"global"}, // NoRoute is wrapped by global middlewares
+	} {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if chain := w.Header().Values("X-Chain"); !reflect.DeepEqual(chain, expected) {
+			t.Errorf("chain of %s should be %v, but got %v", path, expected, chain)
+		}
+	}
+	registry := GetMiddlewareRegistry(refPtr)
+	if names := registry.Names(); !reflect.DeepEqual(names, []string{"a", "b", "c", "global", "trace"}) {
+		t.Errorf("incorrect registered middlewares: %v", names)
+	}
+	if r, _ := GetRouteTable(refPtr).Find(http.MethodGet, "/child/ping"); !reflect.DeepEqual(r.Middlewares, []string{"global", "a", "trace"}) {
+		t.Errorf("incorrect middlewares in route table: %v", r.Middlewares)
+	}
+
+	// a middleware in WebConfig conflicts with the one declared by TestChainMiddleware
+	_, _, err = PrepareGin(&TestRegistryServer{}, NewWebConfig().SetRtMode(RtTest).SetMiddleware("a", func(c *gin.Context) {}))
+	if err == nil || !strings.Contains(err.Error(), "'a' is duplicated") {
+		t.Errorf("should be a duplicated error, but got: %v", err)
+	}
+}
+
+type TestAccount struct {
+	Name string
+}
+
+type TestAuthMiddleware struct {
+	WebMiddleware
+}
+
+func (m *TestAuthMiddleware) HandleAuth(ctx struct {
+	WebContext
+	Token string                `http:"X-Token,in=header"`
+	User  Provide[*TestAccount] `http:"user"`
+}) {
+	if len(ctx.Token) > 0 {
+		ctx.User.Set(ctx.WebContext, &TestAccount{Name: ctx.Token})
+	}
+}
+
+func (m *TestAuthMiddleware) HandleRid(ctx struct {
+	WebContext
+	_ Provide[string] `http:"request_id"`
+}) {
+	ctx.Set("request_id", "r1")
+}
+
+type TestContextServer struct {
+	WebServer
+
+	_ *TestAuthMiddleware `di:"^"`
+}
+
+func (s *TestContextServer) GetMe(ctx struct {
+	WebContext `http:"me,middleware=auth"`
+	User       *TestAccount `http:"user,in=context,missing=401"`
+}) {
+	ctx.String(http.StatusOK, ctx.User.Name)
+}
+
+func (s *TestContextServer) GetRid(ctx struct {
+	WebContext `http:"rid,middleware=rid"`
+	Rid        string `http:"request_id,in=ctx"`
+}) {
+	ctx.String(http.StatusOK, ctx.Rid)
+}
+
+type TestBadContextServer struct {
+	WebServer
+
+	_ *TestAuthMiddleware `di:"^"`
+}
+
+func (s *TestBadContextServer) GetNoProvider(ctx struct {
+	WebContext `http:"no_provider"`
+	User       *TestAccount `http:"user,in=context"`
+}) {
+}
+
+func (s *TestBadContextServer) GetWrongType(ctx struct {
+	WebContext `http:"wrong_type,middleware=auth"`
+	User       string `http:"user,in=context"`
+}) {
+}
+
+func (s *TestBadContextServer) GetBadMissing(ctx struct {
+	WebContext `http:"bad_missing,middleware=auth"`
+	User       *TestAccount `http:"user,in=context,missing=abc"`
+}) {
+}
+
+// go test ./ -v -run TestContextValue
+func TestContextValue(t *testing.T) {
+	config := NewWebConfig().SetRtMode(RtTest).SetOpenApi(func(o *OpenApiConfig) { o.Enable() })
+	engine, refPtr, err := PrepareGin(&TestContextServer{}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		path, token string
+		status      int
+		body        string
+	}{
+		{"/me", "alice", http.StatusOK, "alice"},
+		{"/me", "", http.StatusUnauthorized, `{"message":"context value 'user' is missing","code":"401"}`},
+		{"/rid", "", http.StatusOK, "r1"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, c.path, nil)
+		req.Header.Set("X-Token", c.token)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		if w.Code != c.status || w.Body.String() != c.body {
+			t.Errorf("%s(%s) should be %d %s, but got %d %s", c.path, c.token, c.status, c.body, w.Code, w.Body.String())
+		}
+	}
+	op := GetDynamicRoutes(refPtr).Openapi().Paths["/me"].Get
+	if _, exists := op.Responses["401"]; !exists || len(op.Parameters) != 0 {
+		t.Errorf("incorrect operation: %+v", op)
+	}
+
+	_, _, err = PrepareGin(&TestBadContextServer{}, NewWebConfig().SetRtMode(RtTest))
+	var errs SetupErrors
+	if !errors.As(err, &errs) || len(errs) != 3 {
+		t.Fatalf("should collect 3 errors, but got: %v", err)
+	}
+	t.Log(errs)
+}
+
+type TestBenchServer struct {
+	WebServer
+}
+
+func (s *TestBenchServer) GetSearch(ctx struct {
+	WebContext `http:"search"`
+	Q          string `http:"q"`
+	Page       int    `http:"page"`
+	Size       int    `http:"size"`
+	Desc       bool   `http:"desc"`
+}) {
+	ctx.Status(http.StatusNoContent)
+}
+
+func (s *TestBenchServer) GetItem(ctx struct {
+	WebContext `http:"items/:id"`
+	Id         int `http:"id,in=path"`
+}) {
+	ctx.Status(http.StatusNoContent)
+}
+
+func (s *TestBenchServer) PostItem(ctx struct {
+	WebContext `http:"items,json"`
+	Item       struct {
+		Name  string  `json:"name"`
+		Price float64 `json:"price"`
+	}
+}) {
+	ctx.Status(http.StatusNoContent)
+}
+
+type TestPriority int
+
+func (s *TestBenchServer) GetEcho(ctx struct {
+	WebContext `http:"echo/:id"`
+	Id         uint         `http:"id,in=path"`
+	Priority   TestPriority `http:"priority"`
+	Ratio      float32      `http:"ratio"`
+	Tags       []string     `http:"tags"`
+	token      string       `http:"X-Token,in=header"`
+	_          int          `http:"ignored"`
+}) {
+	ctx.String(http.StatusOK, fmt.Sprintf("%d %d %g %v %s", ctx.Id, ctx.Priority, ctx.Ratio, ctx.Tags, ctx.token))
+}
+
+// go test ./ -v -run TestBindingPlan
+func TestBindingPlan(t *testing.T) {
+	engine, _, err := PrepareGin(&TestBenchServer{}, NewWebConfig().SetRtMode(RtTest).SetDefaultWriter(io.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, expected := range map[string]string{
+		`/echo/7?priority=3&ratio=0.5&tags=a&tags=b&ignored=1`: "7 3 0.5 [a b] secret",
+		`/echo/7?ratio=0.5`: "7 0 0.5 [] secret",
+	} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Token", "secret")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		if w.Body.String() != expected {
+			t.Errorf("%s should be %q, but got %q", path, expected, w.Body.String())
+		}
+	}
+}
+
+type TestStyleServer struct {
+	WebServer
+}
+
+type TestFilter struct {
+	Age  int    `json:"age"`
+	Name string `json:"name"`
+}
+
+func (s *TestStyleServer) GetIds(ctx struct {
+	WebContext `http:"ids"`
+	Ids        []int `http:"ids"`
+	Csv        []int `http:"csv,explode=false"`
+	Pipe       []int `http:"pipe,style=pipeDelimited"`
+}) {
+	ctx.String(http.StatusOK, fmt.Sprint(ctx.Ids, ctx.Csv, ctx.Pipe))
+}
+
+func (s *TestStyleServer) GetFilter(ctx struct {
+	WebContext `http:"filter"`
+	Filter     TestFilter `http:"filter,in=query,style=deepObject"`
+	Since      time.Time  `http:"since"`
+}) {
+	ctx.String(http.StatusOK, fmt.Sprint(ctx.Filter, " ", ctx.Since.Year()))
+}
+
+func (s *TestStyleServer) GetPoint(ctx struct {
+	WebContext `http:"point/:xy/:tags"`
+	Xy         []int    `http:"xy,in=path,style=matrix,explode"`
+	Tags       []string `http:"tags,in=path,style=label"`
+	Ids        []int    `http:"X-Ids,in=header"`
+}) {
+	ctx.String(http.StatusOK, fmt.Sprint(ctx.Xy, ctx.Tags, ctx.Ids))
+}
+
+type TestBadStyleServer struct {
+	WebServer
+}
+
+func (s *TestBadStyleServer) GetBad(ctx struct {
+	WebContext `http:"bad"`
+	Pipe       int        `http:"pipe,style=pipeDelimited"`
+	Deep       []int      `http:"deep,style=deepObject"`
+	Label      []int      `http:"label,style=label"`
+	Explode    []int      `http:"explode,explode=maybe"`
+	Unknown    TestFilter `http:"unknown,style=unknown"`
+}) {
+}
+
+// go test ./ -v -run TestParamStyle
+func TestParamStyle(t *testing.T) {
+	config := NewWebConfig().SetRtMode(RtTest).SetOpenApi(func(o *OpenApiConfig) { o.Enable() })
+	engine, refPtr, err := PrepareGin(&TestStyleServer{}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, expected := range map[string]string{
+		`/ids?ids=1&ids=2&csv=3,4&pipe=5|6`: "[1 2] [3 4] [5 6]",
+		`/ids?csv=&pipe=`:                   "[] [] []",
+		`/filter?filter[age]=3&filter[name]=x&since=2022-10-01T00:00:00Z`: "{3 x} 2022",
+		`/point/;xy=1;xy=2/.a,b`: "[1 2] [a b] [7 8]",
+	} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Ids", "7,8")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		if w.Body.String() != expected {
+			t.Errorf("%s should be %q, but got %q", path, expected, w.Body.String())
+		}
+	}
+	openapi := GetDynamicRoutes(refPtr).Openapi()
+	for _, param := range openapi.Paths["/ids"].Get.Parameters {
+		if param.Name == "csv" && (param.Style != StyleForm || param.Explode == nil || *param.Explode) {
+			t.Errorf("incorrect parameter: %+v", param)
+		}
+		if param.Name == "pipe" && param.Style != StylePipeDelimited {
+			t.Errorf("incorrect parameter: %+v", param)
+		}
+	}
+
+	_, _, err = PrepareGin(&TestBadStyleServer{}, NewWebConfig().SetRtMode(RtTest))
+	var errs SetupErrors
+	if !errors.As(err, &errs) || len(errs) != 5 {
+		t.Fatalf("should collect 5 errors, but got: %v", err)
+	}
+	t.Log(errs)
+}
+
+type TestFailureServer struct {
+	WebServer
+}
+
+type TestFailureItem struct {
+	Name  string  `json:"name" validate:"required"`
+	Price float64 `json:"price"`
+}
+
+type TestFailureNote struct {
+	Text string `json:"text"`
+}
+
+func (s *TestFailureServer) GetItem(ctx struct {
+	WebContext `http:"items/:id"`
+	Id         int   `http:"id,in=path"`
+	Page       int   `http:"page,in=query" validate:"min=1"`
+	Ids        []int `http:"ids,explode=false"`
+	Size       int   `http:"size" validate:"max=100"` // the way is resolved without in attribute
+}) {
+	ctx.Status(http.StatusNoContent)
+}
+
+func (s *TestFailureServer) PostItem(ctx struct {
+	WebContext `http:"items,json"`
+	Item       TestFailureItem
+}) {
+	ctx.Status(http.StatusNoContent)
+}
+
+func (s *TestFailureServer) PatchItem(ctx struct {
+	WebContext `http:"items,json"`
+	Item       *TestFailureItem `http:"item,required"`
+}) {
+	ctx.Status(http.StatusNoContent)
+}
+
+func (s *TestFailureServer) PutNote(ctx struct {
+	WebContext `http:"notes,json"`
+	Note       TestFailureNote
+}) {
+	if ctx.Note.Text != "" {
+		ctx.Status(http.StatusBadRequest)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// go test ./ -v -run TestBindingFailure
+func TestBindingFailure(t *testing.T) {
+	config := NewWebConfig().SetRtMode(RtTest).SetOpenApi(func(o *OpenApiConfig) { o.Enable() })
+	engine, refPtr, err := PrepareGin(&TestFailureServer{}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		method, path, body string
+		status             int
+		details            []ParamError
+	}{
+		{http.MethodGet, "/items/1?page=2&ids=3,4", "", http.StatusNoContent, nil},
+		{http.MethodGet, "/items/x?page=y&ids=3,z", "", http.StatusBadRequest, []ParamError{
+			{Name: "id", In: InPathWay, Value: "x", Type: "int"},
+			{Name: "page", In: InQueryWay, Value: "y", Type: "int"},
+			{Name: "ids", In: InQueryWay, Value: "z", Type: "[]int"},
+		}},
+		{http.MethodGet, "/items/1?page=0", "", http.StatusBadRequest, []ParamError{
+			{Name: "page", In: InQueryWay, Value: "0", Type: "int"},
+		}},
+		{http.MethodGet, "/items/1?page=1&size=big", "", http.StatusBadRequest, []ParamError{
+			{Name: "size", In: InQueryWay, Value: "big", Type: "int"},
+		}},
+		{http.MethodGet, "/items/1?page=1&size=200", "", http.StatusBadRequest, []ParamError{
+			{Name: "size", In: InQueryWay, Value: "200", Type: "int"},
+		}},
+		{http.MethodPost, "/items", `{"name":"a","price":"free"}`, http.StatusBadRequest, []ParamError{
+			{Name: "Item", In: InBodyWay, Type: "dij_gin_test.TestFailureItem"},
+		}},
+		{http.MethodPost, "/items", `{"price":1}`, http.StatusBadRequest, []ParamError{
+			{Name: "Name", In: InBodyWay, Type: "string"},
+		}},
+		// an empty body leaves the struct zero, unless it's required
+		{http.MethodPut, "/notes", "", http.StatusNoContent, nil},
+		{http.MethodPatch, "/items", "", http.StatusBadRequest, []ParamError{
+			{Name: "item", In: InBodyWay, Type: "*dij_gin_test.TestFailureItem"},
+		}},
+	} {
+		req := httptest.NewRequest(c.method, c.path, strings.NewReader(c.body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		if w.Code != c.status {
+			t.Errorf("%s %s should be %d, but got %d %s", c.method, c.path, c.status, w.Code, w.Body.String())
+			continue
+		}
+		if c.details == nil {
+			continue
+		}
+		var webErr WebError
+		if err := json.Unmarshal(w.Body.Bytes(), &webErr); err != nil || webErr.Code != "400" {
+			t.Fatalf("incorrect error: %s", w.Body.String())
+		}
+		for i := range webErr.Details {
+			webErr.Details[i].Message = "" // messages come from parsers and validator
+		}
+		if !reflect.DeepEqual(webErr.Details, c.details) {
+			t.Errorf("%s %s should fail with %+v, but got %+v", c.method, c.path, c.details, webErr.Details)
+		}
+	}
+	op := GetDynamicRoutes(refPtr).Openapi().Paths["/items/{id}"].Get
+	if _, exists := op.Responses["400"]; !exists {
+		t.Errorf("400 should be documented: %+v", op.Responses)
+	}
+}
+
+// go test ./ -v -run TestGetRequestValueForType
+func TestGetRequestValueForType(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/items?page=2&size=big", nil)
+	ctx := &WebContext{Context: c}
+	if v, exists := ctx.GetRequestValueForType("page", reflect.TypeOf(0), InQueryWay); !exists || v != 2 {
+		t.Errorf("page should be 2, but got %v %v", v, exists)
+	}
+	if v, exists := ctx.GetRequestValueForType("size", reflect.TypeOf(0), InQueryWay); exists {
+		t.Errorf("size can't be converted, but got %v", v)
+	}
+	if v, exists := ctx.GetRequestValueForType("sort", reflect.TypeOf(""), InQueryWay); exists {
+		t.Errorf("sort doesn't exist, but got %v", v)
+	}
+}
+
+type TestDefaultServer struct {
+	WebServer
+}
+
+func (s *TestDefaultServer) GetList(ctx struct {
+	WebContext `http:"list"`
+	Q          string    `http:"q,in=query,required"`
+	Page       int       `http:"page,default=1"`
+	Sort       []string  `http:"sort,explode=false,default=name&id"`
+	Since      time.Time `http:"since,default=2022-01-01T00:00:00Z"`
+	Token      string    `http:"X-Token,in=header,required"`
+}) {
+	ctx.Sort[0] = "changed" // doesn't change the default value
+	ctx.String(http.StatusOK, fmt.Sprintf("%s %d %v %d", ctx.Q, ctx.Page, ctx.Sort, ctx.Since.Year()))
+}
+
+type TestBadDefaultServer struct {
+	WebServer
+}
+
+func (s *TestBadDefaultServer) GetBad(ctx struct {
+	WebContext `http:"bad"`
+	Page       int        `http:"page,default=first"`
+	Ids        []int      `http:"ids,default=1&x"`
+	Filter     TestFilter `http:"filter,in=query,default=x"`
+	Size       int        `http:"size,required,default=10"`
+}) {
+}
+
+// go test ./ -v -run TestDefaultAndRequired
+func TestDefaultAndRequired(t *testing.T) {
+	config := NewWebConfig().SetRtMode(RtTest).SetOpenApi(func(o *OpenApiConfig) { o.Enable() })
+	engine, refPtr, err := PrepareGin(&TestDefaultServer{}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		path, token string
+		status      int
+		body        string
+	}{
+		{"/list?q=a", "t", http.StatusOK, "a 1 [changed id] 2022"},
+		{"/list?q=a&page=3&sort=x", "t", http.StatusOK, "a 3 [changed] 2022"},
+		{"/list?q=a", "t", http.StatusOK, "a 1 [changed id] 2022"}, // the default isn't changed by the previous request
+		{"/list", "", http.StatusBadRequest, ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, c.path, nil)
+		if len(c.token) > 0 {
+			req.Header.Set("X-Token", c.token)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		if w.Code != c.status {
+			t.Errorf("%s should be %d, but got %d %s", c.path, c.status, w.Code, w.Body.String())
+		} else if c.status == http.StatusOK && w.Body.String() != c.body {
+			t.Errorf("%s should be %q, but got %q", c.path, c.body, w.Body.String())
+		} else if c.status == http.StatusBadRequest {
+			var webErr WebError
+			if err := json.Unmarshal(w.Body.Bytes(), &webErr); err != nil || len(webErr.Details) != 2 ||
+				webErr.Details[0].Name != "q" || webErr.Details[1].Name != "X-Token" {
+				t.Errorf("%s should fail with q and X-Token, but got %s", c.path, w.Body.String())
+			}
+		}
+	}
+	for _, param := range GetDynamicRoutes(refPtr).Openapi().Paths["/list"].Get.Parameters {
+		switch param.Name {
+		case "page":
+			if param.Schema.Default != 1 {
+				t.Errorf("incorrect default of page: %v", param.Schema.Default)
+			}
+		case "sort":
+			if !reflect.DeepEqual(param.Schema.Default, []string{"name", "id"}) {
+				t.Errorf("incorrect default of sort: %v", param.Schema.Default)
+			}
+		case "q":
+			if !param.Required {
+				t.Errorf("q should be required")
+			}
+		}
+	}
+
+	_, _, err = PrepareGin(&TestBadDefaultServer{}, NewWebConfig().SetRtMode(RtTest))
+	var errs SetupErrors
+	if !errors.As(err, &errs) || len(errs) != 4 {
+		t.Fatalf("should collect 4 errors, but got: %v", err)
+	}
+	t.Log(errs)
+}
+
+type TestUploadServer struct {
+	WebServer
+}
+
+func (s *TestUploadServer) PostUpload(ctx struct {
+	WebContext `http:"upload,multipart"`
+	Title      string                  `http:"title,in=body"`
+	Avatar     FileStream              `http:"avatar,required,maxsize=16B,accept=text/plain"`
+	Photos     []*multipart.FileHeader `http:"photos,maxcount=2,accept=image/*"`
+}) {
+	data, _ := io.ReadAll(ctx.Avatar)
+	ctx.String(http.StatusOK, fmt.Sprintf("%s %s %s %d", ctx.Title, ctx.Avatar.Filename, data, len(ctx.Photos)))
+}
+
+func (s *TestUploadServer) PostAvatar(ctx struct {
+	WebContext `http:"avatar,multipart"`
+	Avatar     FileStream `http:"avatar,required,maxsize=1KB"`
+}) {
+	ctx.Status(http.StatusNoContent)
+}
+
+// testCountingReader counts the bytes which are read.
+type testCountingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *testCountingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// testZeroReader reads zeros endlessly.
+type testZeroReader struct{}
+
+func (testZeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+type TestBadUploadServer struct {
+	WebServer
+}
+
+func (s *TestBadUploadServer) GetUpload(ctx struct {
+	WebContext `http:"upload"`
+	Query      *multipart.FileHeader   `http:"query,in=query"`
+	Single     *multipart.FileHeader   `http:"single,maxcount=1"`
+	Size       []*multipart.FileHeader `http:"size,maxsize=big"`
+	Default    FileStream              `http:"default,default=x"`
+}) {
+}
+
+func newMultipartRequest(t *testing.T, fields map[string]string, files map[string][]string) *http.Request {
+	body := &strings.Builder{}
+	mw := multipart.NewWriter(body)
+	for name, value := range fields {
+		_ = mw.WriteField(name, value)
+	}
+	for name, list := range files {
+		for _, file := range list {
+			// file is "filename:content type:content"
+			parts := strings.SplitN(file, ":", 3)
+			header := textproto.MIMEHeader{}
+			header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, name, parts[0]))
+			header.Set("Content-Type", parts[1])
+			part, err := mw.CreatePart(header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _ = part.Write([]byte(parts[2]))
+		}
+	}
+	_ = mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(body.String()))
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+// go test ./ -v -run TestFileUpload
+func TestFileUpload(t *testing.T) {
+	config := NewWebConfig().SetRtMode(RtTest).SetOpenApi(func(o *OpenApiConfig) { o.Enable() })
+	engine, refPtr, err := PrepareGin(&TestUploadServer{}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		files  map[string][]string
+		status int
+		body   string
+	}{
+		{map[string][]string{"avatar": {"a.txt:text/plain:hello"}, "photos": {"1.png:image/png:x", "2.jpg:image/jpeg:y"}},
+			http.StatusOK, "test a.txt hello 2"},
+		{map[string][]string{"avatar": {"a.txt:text/plain:hello"}}, http.StatusOK, "test a.txt hello 0"},
+		{map[string][]string{}, http.StatusBadRequest, "required"},
+		{map[string][]string{"avatar": {"a.txt:text/plain:more than sixteen bytes"}}, http.StatusBadRequest, "too large"},
+		{map[string][]string{"avatar": {"a.bin:application/octet-stream:x"}}, http.StatusBadRequest, "unacceptable type"},
+		{map[string][]string{"avatar": {"a.txt:text/plain:x"}, "photos": {"1.png:image/png:x", "2.png:image/png:y", "3.png:image/png:z"}},
+			http.StatusBadRequest, "too many files"},
+	} {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, newMultipartRequest(t, map[string]string{"title": "test"}, c.files))
+		if w.Code != c.status || !strings.Contains(w.Body.String(), c.body) {
+			t.Errorf("%v should be %d %s, but got %d %s", c.files, c.status, c.body, w.Code, w.Body.String())
+		}
+	}
+	// an oversized body is rejected without reading all of it
+	const boundary = "oversized"
+	reader := &testCountingReader{Reader: io.MultiReader(
+		strings.NewReader("--"+boundary+"\r\nContent-Disposition: form-data; name=\"avatar\"; filename=\"a.bin\"\r\n\r\n"),
+		io.LimitReader(testZeroReader{}, 64<<20),
+		strings.NewReader("\r\n--"+boundary+"--\r\n"))}
+	req := httptest.NewRequest(http.MethodPost, "/avatar", reader)
+	req.Header.Set("Content-Type", "multipart/form-data; boundary="+boundary)
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge || reader.n > 2<<20 {
+		t.Errorf("oversized body should be rejected with 413 after reading %d bytes, but got %d %s", reader.n, w.Code, w.Body.String())
+	}
+
+	body := GetDynamicRoutes(refPtr).Openapi().Paths["/upload"].Post.RequestBody
+	media, exists := body.Content[spec.MultipartForm]
+	if !exists {
+		t.Fatalf("multipart request body should be documented: %+v", body)
+	}
+	props := media.Schema.Properties
+	if avatar := props["avatar"]; avatar.Type != "string" || avatar.Format != "binary" {
+		t.Errorf("incorrect schema of avatar: %+v", avatar.Schema)
+	}
+	if photos := props["photos"]; photos.Type != "array" || photos.Items.Format != "binary" || photos.MaxItems != 2 {
+		t.Errorf("incorrect schema of photos: %+v", photos.Schema)
+	}
+	if props["title"].Type != "string" || !reflect.DeepEqual(media.Schema.Required, []string{"avatar"}) {
+		t.Errorf("incorrect schema: %+v", media.Schema.Schema)
+	}
+	if media.Encoding["photos"].ContentType != "image/*" || media.Encoding["avatar"].ContentType != "text/plain" {
+		t.Errorf("incorrect encoding: %+v", media.Encoding)
+	}
+
+	_, _, err = PrepareGin(&TestBadUploadServer{}, NewWebConfig().SetRtMode(RtTest))
+	var errs SetupErrors
+	if !errors.As(err, &errs) || len(errs) != 5 {
+		t.Fatalf("should collect 5 errors, but got: %v", err)
+	}
+	t.Log(errs)
+}
+
+func benchmarkHandler(b *testing.B, method, path, body string) {
+	config := NewWebConfig().SetRtMode(RtTest).SetDefaultWriter(io.Discard).
+		SetEngine(func(e *EngineConfig) { e.SetBaseMiddlewares() })
+	engine, _, err := PrepareGin(&TestBenchServer{}, config)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if len(body) > 0 {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		if w.Code != http.StatusNoContent {
+			b.Fatalf("unexpected status: %d", w.Code)
+		}
+	}
+}
+
+// go test ./ -run ^$ -bench BenchmarkQueryHandler -benchmem
+func BenchmarkQueryHandler(b *testing.B) {
+	benchmarkHandler(b, http.MethodGet, "/search?q=gopher&page=2&size=20&desc=true", "")
+}
+
+// go test ./ -run ^$ -bench BenchmarkPathHandler -benchmem
+func BenchmarkPathHandler(b *testing.B) {
+	benchmarkHandler(b, http.MethodGet, "/items/123", "")
+}
+
+// go test ./ -run ^$ -bench BenchmarkBodyHandler -benchmem
+func BenchmarkBodyHandler(b *testing.B) {
+	benchmarkHandler(b, http.MethodPost, "/items", `{"name":"gopher","price":9.9}`)
+}
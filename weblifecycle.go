@@ -0,0 +1,273 @@
+// Copyright 2022 Yuchi Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package dij_gin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/letscool/lc-go/dij"
+	. "github.com/letscool/lc-go/lg"
+	"net"
+	"net/http"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+)
+
+// WillShutdownHandler can be implemented by any instance resolved through dij (controllers, middlewares, etc.).
+type WillShutdownHandler interface {
+	// WillShutdown will be called before the web server stops accepting new requests.
+	// Dependents are notified before their dependencies.
+	WillShutdown()
+}
+
+// DidShutdownHandler can be implemented by any instance resolved through dij (controllers, middlewares, etc.).
+type DidShutdownHandler interface {
+	// DidShutdown will be called after all in-flight requests are drained or the grace period is over.
+	// Dependents are notified before their dependencies.
+	DidShutdown()
+}
+
+// GinHandle controls a launched web server.
+type GinHandle struct {
+	engine   *gin.Engine
+	ref      dij.DependencyReferencePtr
+	config   *WebConfig
+	logger   WebLogger // the logger shared by PrepareGin
+	server   *http.Server
+	listener net.Listener
+
+	shutdownOnce sync.Once
+	doneOnce     sync.Once
+	done         chan struct{}
+	err          error
+}
+
+// StartGin prepares a web server like PrepareGin, and serves it in background.
+// The server will be shut down gracefully when ctx is done or SIGINT/SIGTERM is received,
+// in-flight requests are drained within WebConfig.ShutdownTimeout.
+//
+//	handle, err := StartGin(ctx, &WebSer{}, config)
+//	if err != nil {
+//	  log.Fatalln(err)
+//	}
+//	log.Println("listen on", handle.Addr())
+//	if err := handle.Wait(); err != nil {
+//	  log.Fatalln(err)
+//	}
+func StartGin(ctx context.Context, webServerTypeOrInst any, others ...any) (*GinHandle, error) {
+	engine, refPtr, err := PrepareGin(webServerTypeOrInst, others...)
+	if err != nil {
+		return nil, err
+	}
+	config := (*refPtr)[RefKeyForWebConfig].(*WebConfig)
+	tlsConfig, err := config.BuildTlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{
+		Addr:      config.ServerAddr(),
+		Handler:   engine,
+		TLSConfig: tlsConfig,
+	}
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if config.MaxConn > 0 {
+		listener = LimitListener(listener, config.MaxConn)
+	}
+
+	h := &GinHandle{
+		engine:   engine,
+		ref:      refPtr,
+		config:   config,
+		logger:   (*refPtr)[RefKeyForWebLogger].(WebLogger),
+		server:   server,
+		listener: listener,
+		done:     make(chan struct{}),
+	}
+	h.logger.Log(LogInfo, "listening and serving "+Ife(tlsConfig != nil, "HTTPS", "HTTP"), LogKV("addr", h.Addr()))
+	go h.serve()
+	go h.watch(ctx)
+	return h, nil
+}
+
+func (h *GinHandle) serve() {
+	var err error
+	if h.server.TLSConfig != nil {
+		// certificates are already loaded into TLSConfig
+		err = h.server.ServeTLS(h.listener, "", "")
+	} else {
+		err = h.server.Serve(h.listener)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		h.finish(err)
+	}
+}
+
+func (h *GinHandle) watch(ctx context.Context) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	sigCtx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	select {
+	case <-sigCtx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), h.config.ShutdownTimeout)
+		defer cancel()
+		if err := h.Shutdown(shutdownCtx); err != nil {
+			h.logger.Log(LogError, "shutdown web server error", LogKV("error", err))
+		}
+	case <-h.done:
+	}
+}
+
+func (h *GinHandle) finish(err error) {
+	h.doneOnce.Do(func() {
+		h.err = err
+		close(h.done)
+	})
+}
+
+// IsTls reports whether the server serves https.
+func (h *GinHandle) IsTls() bool {
+	return h.server.TLSConfig != nil
+}
+
+// Addr returns the address which the server listens on, ex: "127.0.0.1:8000".
+func (h *GinHandle) Addr() string {
+	return h.listener.Addr().String()
+}
+
+// Engine returns the underlying gin engine.
+func (h *GinHandle) Engine() *gin.Engine {
+	return h.engine
+}
+
+// Ref returns the dependency reference which built the web server.
+func (h *GinHandle) Ref() dij.DependencyReferencePtr {
+	return h.ref
+}
+
+// RouteTable returns all routes registered by the server.
+func (h *GinHandle) RouteTable() *RouteTable {
+	return GetRouteTable(h.ref)
+}
+
+// Done returns a channel that is closed when the server is stopped.
+func (h *GinHandle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Wait blocks until the server is stopped. It returns nil after a graceful shutdown.
+func (h *GinHandle) Wait() error {
+	<-h.done
+	return h.err
+}
+
+// Shutdown stops the server gracefully. WillShutdown hooks are called first, then in-flight requests are drained
+// until ctx is done (the remaining connections are closed forcibly), and DidShutdown hooks are called at last.
+// Calling it more than once waits for the first shutdown and returns its result.
+func (h *GinHandle) Shutdown(ctx context.Context) error {
+	h.shutdownOnce.Do(func() {
+		handlers := collectShutdownHandlers(h.ref)
+		for _, handler := range handlers {
+			if w, ok := handler.(WillShutdownHandler); ok {
+				w.WillShutdown()
+			}
+		}
+		err := h.server.Shutdown(ctx)
+		if err != nil {
+			if closeErr := h.server.Close(); closeErr != nil {
+				err = fmt.Errorf("%v, and close server error: %w", err, closeErr)
+			}
+		}
+		for _, handler := range handlers {
+			if d, ok := handler.(DidShutdownHandler); ok {
+				d.DidShutdown()
+			}
+		}
+		h.finish(err)
+	})
+	return h.Wait()
+}
+
+// collectShutdownHandlers lists instances created by dij in reverse post-order of the dependency graph,
+// so dependents always come before their dependencies, even if a dependency is shared by several dependents.
+func collectShutdownHandlers(refPtr dij.DependencyReferencePtr) []any {
+	stack := refPtr.StackHistory()
+	recorded := map[any]bool{}
+	instances := make([]any, 0, stack.NumOfRecords())
+	for i := 0; i < stack.NumOfRecords(); i++ {
+		inst := stack.GetRecord(i).Inst
+		if inst == nil || isHashable(inst) && recorded[inst] {
+			continue
+		}
+		if isHashable(inst) {
+			recorded[inst] = true
+		}
+		instances = append(instances, inst)
+	}
+	handlers := make([]any, 0, len(instances))
+	visited := map[any]bool{}
+	var visit func(inst any)
+	visit = func(inst any) {
+		if !isHashable(inst) {
+			handlers = append(handlers, inst) // maps, slices and funcs don't refer to other instances by fields
+			return
+		} else if visited[inst] {
+			return
+		}
+		visited[inst] = true
+		for _, dep := range dependenciesOf(reflect.ValueOf(inst), recorded) {
+			visit(dep)
+		}
+		handlers = append(handlers, inst) // post-order, dependencies come first
+	}
+	for _, inst := range instances {
+		visit(inst)
+	}
+	for i, j := 0, len(handlers)-1; i < j; i, j = i+1, j-1 {
+		handlers[i], handlers[j] = handlers[j], handlers[i]
+	}
+	return handlers
+}
+
+// isHashable reports whether the value can be a key of map, ex: an interface field may hold a map, slice or func.
+func isHashable(v any) bool {
+	return reflect.TypeOf(v).Comparable()
+}
+
+// dependenciesOf returns the recorded instances referred by fields of the instance, fields of embedded structs are
+// included.
+func dependenciesOf(instVal reflect.Value, recorded map[any]bool) (deps []any) {
+	if instVal.Kind() == reflect.Pointer {
+		if instVal.IsNil() {
+			return
+		}
+		instVal = instVal.Elem()
+	}
+	if instVal.Kind() != reflect.Struct || !instVal.CanAddr() {
+		return
+	}
+	for i := 0; i < instVal.NumField(); i++ {
+		field := instVal.Field(i)
+		field = reflect.NewAt(field.Type(), field.Addr().UnsafePointer()).Elem()
+		switch {
+		case instVal.Type().Field(i).Anonymous && field.Kind() == reflect.Struct:
+			deps = append(deps, dependenciesOf(field, recorded)...)
+		case (field.Kind() == reflect.Pointer || field.Kind() == reflect.Interface) && !field.IsNil():
+			if dep := field.Interface(); isHashable(dep) && recorded[dep] {
+				deps = append(deps, dep)
+			}
+		}
+	}
+	return
+}
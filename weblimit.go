@@ -0,0 +1,102 @@
+// Copyright 2022 Yuchi Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package dij_gin
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+const (
+	ConcurrencyRetryAfter   = 1 // seconds for Retry-After header when route concurrency is saturated.
+	OpenApiConcurrencyLimit = "x-concurrency-limit"
+)
+
+// LimitListener returns a listener that accepts at most n simultaneous connections from the provided listener.
+// The connection over the limit waits until an accepted connection is closed.
+func LimitListener(l net.Listener, n int) net.Listener {
+	return &limitListener{
+		Listener: l,
+		sem:      make(chan struct{}, n),
+		done:     make(chan struct{}),
+	}
+}
+
+type limitListener struct {
+	net.Listener
+	sem       chan struct{}
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func (l *limitListener) acquire() bool {
+	select {
+	case <-l.done:
+		return false
+	case l.sem <- struct{}{}:
+		return true
+	}
+}
+
+func (l *limitListener) release() {
+	<-l.sem
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	if !l.acquire() {
+		// the listener is closed, call Accept to get the proper error.
+		c, err := l.Listener.Accept()
+		if err == nil {
+			_ = c.Close()
+			err = net.ErrClosed
+		}
+		return nil, err
+	}
+	c, err := l.Listener.Accept()
+	if err != nil {
+		l.release()
+		return nil, err
+	}
+	return &limitListenerConn{Conn: c, release: l.release}, nil
+}
+
+func (l *limitListener) Close() error {
+	err := l.Listener.Close()
+	l.closeOnce.Do(func() { close(l.done) })
+	return err
+}
+
+type limitListenerConn struct {
+	net.Conn
+	releaseOnce sync.Once
+	release     func()
+}
+
+func (c *limitListenerConn) Close() error {
+	err := c.Conn.Close()
+	c.releaseOnce.Do(c.release)
+	return err
+}
+
+// concurrencyLimitHandler limits the number of concurrent requests,
+// the request over the limit is responded 503 with Retry-After header immediately.
+func concurrencyLimitHandler(limit int) gin.HandlerFunc {
+	sem := make(chan struct{}, limit)
+	return func(c *gin.Context) {
+		select {
+		case sem <- struct{}{}:
+			defer func() { <-sem }()
+			c.Next()
+		default:
+			c.Header("Retry-After", strconv.Itoa(ConcurrencyRetryAfter))
+			err := fmt.Errorf("too many concurrent requests, the limit is %d", limit)
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, ToWebError(err, strconv.Itoa(http.StatusServiceUnavailable)))
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2022 Yuchi Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package dij_gin
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+type LogLevel int
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+	LogSilent // disable all logs
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogDebug:
+		return "DEBUG"
+	case LogInfo:
+		return "INFO"
+	case LogWarn:
+		return "WARN"
+	case LogError:
+		return "ERROR"
+	case LogSilent:
+		return "SILENT"
+	}
+	return fmt.Sprintf("LEVEL(%d)", l)
+}
+
+// LogField is a structured field of log, ex: controller, method, path.
+type LogField struct {
+	Key   string
+	Value any
+}
+
+// LogKV creates a structured field of log.
+func LogKV(key string, value any) LogField {
+	return LogField{Key: key, Value: value}
+}
+
+// WebLogger is used by the framework for all logs, set it by WebConfig.SetLogger to integrate your log pipeline.
+type WebLogger interface {
+	Log(level LogLevel, msg string, fields ...LogField)
+}
+
+// NewWebLogger creates a logger which writes text lines to w, the logs lower than level are ignored.
+//
+//	[dij-gin] 2022/12/01 - 15:04:05 | INFO | setup router controller=main.TWebServer path=/
+func NewWebLogger(w io.Writer, level LogLevel) WebLogger {
+	return &textWebLogger{writer: w, level: level}
+}
+
+type textWebLogger struct {
+	mu     sync.Mutex
+	writer io.Writer
+	level  LogLevel
+}
+
+func (l *textWebLogger) Log(level LogLevel, msg string, fields ...LogField) {
+	if level < l.level || l.level >= LogSilent {
+		return
+	}
+	var b strings.Builder
+	b.WriteString("[dij-gin] ")
+	b.WriteString(time.Now().Format("2006/01/02 - 15:04:05"))
+	b.WriteString(" | ")
+	b.WriteString(level.String())
+	b.WriteString(" | ")
+	b.WriteString(msg)
+	for _, f := range fields {
+		b.WriteString(" ")
+		b.WriteString(f.Key)
+		b.WriteString("=")
+		b.WriteString(fmt.Sprint(f.Value))
+	}
+	b.WriteString("\n")
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = io.WriteString(l.writer, b.String())
+}
@@ -4,7 +4,16 @@
 
 package dij_gin
 
-import "reflect"
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/letscool/lc-go/dij"
+	"github.com/letscool/lc-go/lg"
+	"reflect"
+	"sort"
+	"strings"
+)
 
 func IsTypeOfWebMiddleware(typ reflect.Type) bool {
 	if typ.Kind() == reflect.Pointer {
@@ -29,3 +38,296 @@ type WebMiddleware struct {
 func (m *WebMiddleware) iAmAWebMiddleware() {
 
 }
+
+// MiddlewareOptions parses arguments of a parameterized middleware, ex: "5/s" of `middleware=ratelimit(5/s)`.
+// The options field of a middleware handler is marked by "options" attribute:
+//
+//	func (m *TRateLimitMiddleware) HandleRatelimit(ctx struct {
+//	  WebContext
+//	  Rate TRate `http:",options"`
+//	}) {
+//	}
+//
+// If the pointer of field type doesn't implement MiddlewareOptions, the args are converted like a request value.
+// Arguments can't contain comma and ampersand, they are separators of http tag.
+type MiddlewareOptions interface {
+	ParseMiddlewareArgs(args string) error
+}
+
+// newMiddlewareOptions parses args into the type of options field and validates it. It returns zero value of
+// the type if parse is false, ex: a middleware without arguments.
+func newMiddlewareOptions(field BaseParamField, args string, parse bool, valid *validator.Validate) (reflect.Value, error) {
+	typ := field.FieldSpec.Type
+	value := reflect.New(typ).Elem()
+	if parse {
+		target := value
+		if typ.Kind() == reflect.Pointer {
+			value.Set(reflect.New(typ.Elem()))
+			target = value.Elem()
+		}
+		var err error
+		if options, ok := target.Addr().Interface().(MiddlewareOptions); ok {
+			err = options.ParseMiddlewareArgs(args)
+		} else {
+			err = newValueConverter(target.Type())(args, target)
+		}
+		if err != nil {
+			return value, fmt.Errorf("parse arguments(%s) error: %w", args, err)
+		}
+	}
+	if tag := field.FieldSpec.Tag.Get("validate"); len(tag) > 0 {
+		if err := valid.Var(value.Interface(), tag); err != nil {
+			return value, err
+		}
+	}
+	if target := reflect.Indirect(value); target.Kind() == reflect.Struct {
+		if err := valid.Struct(target.Interface()); err != nil {
+			return value, err
+		}
+	}
+	return value, nil
+}
+
+// parseMiddlewareRef parses a middleware in http tag, ex: "ratelimit(5/s)" returns ratelimit and 5/s.
+func parseMiddlewareRef(ref string) (name string, args string, hasArgs bool, ok bool) {
+	open := strings.IndexByte(ref, '(')
+	if open < 0 {
+		return ref, "", false, true
+	}
+	if !strings.HasSuffix(ref, ")") {
+		return "", "", false, false
+	}
+	return strings.TrimSpace(ref[:open]), strings.TrimSpace(ref[open+1 : len(ref)-1]), true, true
+}
+
+// scopedMiddleware is a middleware in the resolved chain of a controller or a handler.
+type scopedMiddleware struct {
+	name     string
+	ref      string // name with arguments, ex: ratelimit(5/s)
+	handler  gin.HandlerFunc
+	provides map[string]reflect.Type // values set into gin context, see Provide
+}
+
+// splitMiddlewareNames splits names of middleware attribute, a name with "-" prefix opts out the inherited middleware.
+// ex: "auth&-log" returns auth in names and log in optOuts.
+func splitMiddlewareNames(value []string) (names []string, optOuts []string) {
+	for _, v := range value {
+		if name := strings.TrimSpace(v); strings.HasPrefix(name, "-") {
+			if name = strings.TrimSpace(name[1:]); len(name) > 0 {
+				optOuts = append(optOuts, name)
+			}
+		} else if len(name) > 0 {
+			names = append(names, name)
+		}
+	}
+	return
+}
+
+// optOutMiddlewares returns a copy of chain without middlewares of names.
+func optOutMiddlewares(chain []scopedMiddleware, names []string, newErr func(format string, args ...any) *SetupError, errs *SetupErrors) []scopedMiddleware {
+	names = lg.Map(names, func(ref string) string {
+		name, _, _, _ := parseMiddlewareRef(ref)
+		return name
+	})
+	for _, name := range names {
+		if !lg.Contains(chainNamesOf(chain), name) {
+			errs.Add(newErr("middleware '%s' to opt out isn't inherited", name))
+		}
+	}
+	return lg.Filter(chain, func(m scopedMiddleware) bool {
+		return !lg.Contains(names, m.name)
+	})
+}
+
+// appendMiddlewares returns a copy of chain with middlewares of refs. A middleware in chain isn't appended again,
+// it's replaced at the same position, so the arguments can be overridden, ex: ratelimit(100/s) overrides the inherited ratelimit(5/s).
+func appendMiddlewares(chain []scopedMiddleware, refs []string, wrappers map[string]HandlerWrapper, newErr func(format string, args ...any) *SetupError, errs *SetupErrors) []scopedMiddleware {
+	chain = append([]scopedMiddleware{}, chain...)
+	for _, ref := range refs {
+		name, args, hasArgs, ok := parseMiddlewareRef(ref)
+		if !ok {
+			errs.Add(newErr("middleware '%s' should be a name or a name with arguments, ex: ratelimit(5/s)", ref))
+			continue
+		}
+		w, b := wrappers[name]
+		if !b {
+			errs.Add(newErr("middleware's handler '%s' doesn't exist", name))
+			continue
+		}
+		m := scopedMiddleware{name: name, ref: name, handler: w.Handler, provides: w.Spec.Provides}
+		var err error
+		if hasArgs {
+			m.ref = name + "(" + args + ")"
+			m.handler, err = w.HandlerWithArgs(args)
+		} else if w.withArgs != nil {
+			m.handler, err = w.withArgs("", false)
+		}
+		if err != nil {
+			errs.Add(newErr("middleware '%s' is misconfigured: %v", m.ref, err))
+			continue
+		}
+		replaced := false
+		for i := range chain {
+			if chain[i].name == name {
+				chain[i], replaced = m, true
+			}
+		}
+		if !replaced {
+			chain = append(chain, m)
+		}
+	}
+	return chain
+}
+
+func chainNamesOf(chain []scopedMiddleware) []string {
+	return lg.Map(chain, func(m scopedMiddleware) string {
+		return m.name
+	})
+}
+
+// middlewareNamesOf returns names with arguments of middlewares, for route table.
+func middlewareNamesOf(chain []scopedMiddleware) []string {
+	return lg.Map(chain, func(m scopedMiddleware) string {
+		return m.ref
+	})
+}
+
+func middlewareHandlersOf(chain []scopedMiddleware) []gin.HandlerFunc {
+	return lg.Map(chain, func(m scopedMiddleware) gin.HandlerFunc {
+		return m.handler
+	})
+}
+
+const RefKeyForWebMiddlewares = "_.webserver.middlewares"
+
+// MiddlewareRegistry is the server-wide middlewares by name. It's populated from WebConfig.Middlewares and
+// middleware fields of all controllers in the tree before routes are set up, so a controller can use the middlewares
+// declared by its parent, the web server or any other controller. A name declared by different instances is a setup error.
+type MiddlewareRegistry struct {
+	wrappers map[string]HandlerWrapper
+	owners   map[string]any // instance of middleware, or WebConfig
+	globals  []scopedMiddleware
+}
+
+// GetMiddlewareRegistry retrieves the middleware registry created by PrepareGin.
+func GetMiddlewareRegistry(refPtr dij.DependencyReferencePtr) *MiddlewareRegistry {
+	if v, ok := refPtr.Get(RefKeyForWebMiddlewares); ok {
+		return v.(*MiddlewareRegistry)
+	}
+	return nil
+}
+
+func newMiddlewareRegistry() *MiddlewareRegistry {
+	return &MiddlewareRegistry{wrappers: map[string]HandlerWrapper{}, owners: map[string]any{}}
+}
+
+// clone copies the registry, so middlewares of a dynamic controller aren't registered into the registry of web server.
+func (r *MiddlewareRegistry) clone() *MiddlewareRegistry {
+	c := newMiddlewareRegistry()
+	for k, v := range r.wrappers {
+		c.wrappers[k] = v
+		c.owners[k] = r.owners[k]
+	}
+	c.globals = r.globals
+	return c
+}
+
+// Names returns sorted names of registered middlewares.
+func (r *MiddlewareRegistry) Names() []string {
+	names := make([]string, 0, len(r.wrappers))
+	for name := range r.wrappers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Lookup returns the middleware handler of the name.
+func (r *MiddlewareRegistry) Lookup(name string) (HandlerWrapper, bool) {
+	w, ok := r.wrappers[name]
+	return w, ok
+}
+
+// Globals returns names with arguments of global middlewares.
+func (r *MiddlewareRegistry) Globals() []string {
+	return middlewareNamesOf(r.globals)
+}
+
+// register adds the middleware handler declared by owner, the same owner can be registered again, ex: a `di:"^"` field
+// in different controllers.
+func (r *MiddlewareRegistry) register(w HandlerWrapper, owner any) error {
+	name := w.ReqPath()
+	if existing, exists := r.owners[name]; exists {
+		if existing == owner {
+			return nil
+		}
+		return fmt.Errorf("middleware's handler '%s' is duplicated, it's declared by %s and %s", name, ownerName(existing), ownerName(owner))
+	}
+	r.wrappers[name] = w
+	r.owners[name] = owner
+	return nil
+}
+
+func ownerName(owner any) string {
+	if _, ok := owner.(*WebConfig); ok {
+		return "WebConfig"
+	}
+	return fmt.Sprintf("%v", reflect.TypeOf(owner))
+}
+
+// registerConfigMiddlewares registers middlewares of WebConfig.
+func (r *MiddlewareRegistry) registerConfigMiddlewares(config *WebConfig, errs *SetupErrors) {
+	names := make([]string, 0, len(config.Middlewares))
+	for name := range config.Middlewares {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		w := HandlerWrapper{Spec: HandlerSpec{Purpose: HandlerForMid, Path: name}, Handler: config.Middlewares[name]}
+		if err := r.register(w, config); err != nil {
+			errs.Add(&SetupError{Reason: err.Error()})
+		}
+	}
+}
+
+// registerMiddlewares registers middlewares declared by fields of the controller and its extenders.
+func (r *MiddlewareRegistry) registerMiddlewares(instPtr any, instType reflect.Type, refPtr dij.DependencyReferencePtr, errs *SetupErrors) {
+	for i := 0; i < instType.NumField(); i++ {
+		field := instType.Field(i)
+		fieldTyp := field.Type
+		if field.Anonymous || fieldTyp.Kind() != reflect.Pointer || fieldTyp.Elem().Kind() != reflect.Struct {
+			continue // misconfigurations are reported when routes are set up.
+		}
+		isMiddleware, isController := IsTypeOfWebMiddleware(fieldTyp), IsTypeOfWebController(fieldTyp)
+		if !isMiddleware && !isController {
+			continue
+		}
+		fieldIf, ok := fieldInstanceOf(instPtr, instType, i, refPtr)
+		if !ok {
+			continue
+		}
+		if isController {
+			r.registerMiddlewares(fieldIf, fieldTyp.Elem(), refPtr, errs)
+			continue
+		}
+		wrappers, err := GenerateHandlerWrappers(fieldIf, HandlerForMid, refPtr)
+		errs.Append(err)
+		for _, w := range wrappers {
+			if err := r.register(w, fieldIf); err != nil {
+				errs.Add(w.Spec.newSetupError("", "%v", err))
+			}
+		}
+	}
+}
+
+// setGlobals resolves global middlewares of WebConfig.
+func (r *MiddlewareRegistry) setGlobals(refs []string, errs *SetupErrors) {
+	names, optOuts := splitMiddlewareNames(refs)
+	newErr := func(format string, args ...any) *SetupError {
+		return &SetupError{Reason: "global " + fmt.Sprintf(format, args...)}
+	}
+	for _, name := range optOuts {
+		errs.Add(newErr("middleware '-%s' can't opt out", name))
+	}
+	r.globals = appendMiddlewares(nil, names, r.wrappers, newErr, errs)
+}
@@ -0,0 +1,157 @@
+// Copyright 2022 Yuchi Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package dij_gin
+
+import (
+	"github.com/letscool/lc-go/dij"
+	"github.com/letscool/lc-go/lg"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// MountConfig overrides the settings of a mounted controller, it's set by WebConfig.SetMount with the mount name.
+//
+// A controller field with http tag is a mount, the tag overrides the http tag of its embedded WebController,
+// so the same controller type can be mounted at different paths:
+//
+//	type TWebServer struct {
+//	  WebServer
+//
+//	  avatars     *TFileController `di:"" http:"avatars,tag=Avatars"`
+//	  attachments *TFileController `di:"" http:"attachments,tag=Attachments,middleware=auth"`
+//	}
+//
+// The mount name is the value of `mount=name` attribute, or the field name.
+// The fields should have different di names (`di:""` means field name), so every mount has its own instance,
+// an instance shared by mounts (ex: `di:"^"`) is a setup error.
+type MountConfig struct {
+	Path          string         // overrides path of http tag
+	Tag           string         // overrides tag of http tag, ex: "Avatars"
+	Middlewares   []string       // overrides middleware of http tag
+	DependentRefs map[string]any // overrides DependentRefs for the controller instance of the mount
+}
+
+func (m *MountConfig) SetPath(path string) *MountConfig {
+	m.Path = path
+	return m
+}
+
+func (m *MountConfig) SetTag(tag string) *MountConfig {
+	m.Tag = tag
+	return m
+}
+
+func (m *MountConfig) SetMiddlewares(middlewares ...string) *MountConfig {
+	m.Middlewares = append([]string{}, middlewares...)
+	return m
+}
+
+func (m *MountConfig) SetDependentRef(key string, ref any) *MountConfig {
+	if m.DependentRefs == nil {
+		m.DependentRefs = map[string]any{}
+	}
+	m.DependentRefs[key] = ref
+	return m
+}
+
+// httpTag returns the http tag of the overridden settings.
+func (m *MountConfig) httpTag() string {
+	segments := []string{m.Path}
+	if len(m.Tag) > 0 {
+		segments = append(segments, "tag="+m.Tag)
+	}
+	if m.Middlewares != nil {
+		segments = append(segments, "middleware="+strings.Join(m.Middlewares, "&"))
+	}
+	return strings.Join(segments, ",")
+}
+
+// mountPoint is the http tag of a mount field, it overrides the http tag of the embedded WebController of mounted controller.
+type mountPoint struct {
+	name  string
+	attrs lg.StructTagAttrs
+}
+
+// newMountPoint returns the mount of controller field, ok is false if the field isn't a mount.
+func newMountPoint(field reflect.StructField, config *WebConfig) (mount mountPoint, ok bool) {
+	tag, existsTag := field.Tag.Lookup(HttpTagName)
+	attrs := lg.ParseStructTag(tag)
+	mount.name = field.Name
+	if attr, exists := attrs.FirstAttrsWithKey("mount"); exists && len(attr.Val) > 0 {
+		mount.name = attr.Val
+	}
+	mountConfig, existsConfig := config.Mounts[mount.name]
+	if !existsTag && !existsConfig {
+		return mount, false
+	}
+	if existsConfig {
+		attrs = mergeHttpTag(attrs, lg.ParseStructTag(mountConfig.httpTag()))
+	}
+	mount.attrs = attrs
+	return mount, true
+}
+
+// mergeHttpTag merges two http tags, the path and attributes in override replace the ones in base.
+// An empty path in override keeps the path of base.
+func mergeHttpTag(base, override lg.StructTagAttrs) lg.StructTagAttrs {
+	path := ""
+	if attr, ok := base.FirstAttrWithValOnly(); ok {
+		path = attr.Val
+	}
+	if attr, ok := override.FirstAttrWithValOnly(); ok && len(attr.Val) > 0 {
+		path = attr.Val
+	}
+	segments := []string{path}
+	for i, attrs := range []lg.StructTagAttrs{base, override} {
+		for j, attr := range attrs.Attrs() {
+			if j == 0 && attr.ValOnly {
+				continue // path
+			}
+			if i == 0 && !attr.ValOnly {
+				if _, exists := override.FirstAttrsWithKey(attr.Key); exists {
+					continue
+				}
+			}
+			segments = append(segments, attr.Orig)
+		}
+	}
+	return lg.ParseStructTag(strings.Join(segments, ","))
+}
+
+// mountRef returns a copy of refPtr with DependentRefs of the mount, it returns refPtr if the mount doesn't override any of them.
+func mountRef(refPtr dij.DependencyReferencePtr, mountConfig *MountConfig) dij.DependencyReferencePtr {
+	if mountConfig == nil || len(mountConfig.DependentRefs) == 0 {
+		return refPtr
+	}
+	ref := dij.DependencyReference{}
+	for k, v := range *refPtr {
+		if k != dij.StackKey && k != dij.StackDeepKey {
+			ref[k] = v
+		}
+	}
+	for k, v := range mountConfig.DependentRefs {
+		ref[k] = v
+	}
+	ref[RefKeyForWebDijRef] = &ref
+	return &ref
+}
+
+// operationIdOf returns operationId in lower camel case, ex: avatarsGetFile for GetFile method in avatars mount.
+func operationIdOf(mounts []string, methodName string) string {
+	var b strings.Builder
+	for _, s := range append(append([]string{}, mounts...), methodName) {
+		for _, word := range strings.FieldsFunc(s, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+			runes := []rune(word)
+			if b.Len() == 0 {
+				runes[0] = unicode.ToLower(runes[0])
+			} else {
+				runes[0] = unicode.ToUpper(runes[0])
+			}
+			b.WriteString(string(runes))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,46 @@
+// Copyright 2022 Yuchi Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package dij_gin
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/letscool/lc-go/dij"
+)
+
+const RefKeyForWebNoRoute = "_.webserver.noroute"
+
+// NoRouteChain composes the handlers of unmatched requests, fallbacks run before the NoRoute handler of web server.
+type NoRouteChain struct {
+	fallbacks []gin.HandlerFunc
+	handlers  []gin.HandlerFunc // handlers of NoRoute in web server, including its middlewares
+}
+
+// GetNoRouteChain retrieves the chain built by PrepareGin.
+func GetNoRouteChain(refPtr dij.DependencyReferencePtr) *NoRouteChain {
+	if v, ok := refPtr.Get(RefKeyForWebNoRoute); ok {
+		return v.(*NoRouteChain)
+	}
+	return nil
+}
+
+// AddFallback adds a handler for unmatched requests, ex: history fallback of single-page application.
+// The handler should call c.Abort() if it handles the request, otherwise the next fallback or NoRoute handler runs.
+func (n *NoRouteChain) AddFallback(handler gin.HandlerFunc) {
+	n.fallbacks = append(n.fallbacks, handler)
+}
+
+func (n *NoRouteChain) setHandlers(handlers []gin.HandlerFunc) {
+	n.handlers = handlers
+}
+
+// install sets the chain as NoRoute of engine, gin's 404 is kept if the chain is empty.
+func (n *NoRouteChain) install(engine *gin.Engine) {
+	chain := make([]gin.HandlerFunc, 0, len(n.fallbacks)+len(n.handlers))
+	chain = append(chain, n.fallbacks...)
+	chain = append(chain, n.handlers...)
+	if len(chain) > 0 {
+		engine.NoRoute(chain...)
+	}
+}
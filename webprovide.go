@@ -0,0 +1,103 @@
+// Copyright 2022 Yuchi Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package dij_gin
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+)
+
+// Provide declares that a middleware handler sets a value of T into gin context, the key is the name in http tag.
+// Handlers behind the middleware get the value by a field with `in=context` attribute:
+//
+//	func (m *TAuthMiddleware) HandleAuth(ctx struct {
+//	  WebContext
+//	  User Provide[*Account] `http:"user"`
+//	}) {
+//	  ctx.User.Set(ctx.WebContext, account)
+//	}
+//
+//	func (c *TUserController) GetMe(ctx struct {
+//	  WebContext `http:"me,middleware=auth"`
+//	  User       *Account `http:"user,in=context"`
+//	}) {
+//	}
+//
+// Use `_ Provide[*Account] `http:"user"` if the middleware sets the value by gin.Context.Set itself.
+type Provide[T any] struct {
+	key string
+}
+
+// Key returns the key of gin context.
+func (p Provide[T]) Key() string {
+	return p.key
+}
+
+// Set sets the value into gin context.
+func (p Provide[T]) Set(ctx WebContext, value T) {
+	ctx.Set(p.key, value)
+}
+
+func (p Provide[T]) providedType() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+func (p *Provide[T]) setKey(key string) {
+	p.key = key
+}
+
+type contextProvider interface {
+	providedType() reflect.Type
+	setKey(key string)
+}
+
+var contextProviderType = reflect.TypeOf((*contextProvider)(nil)).Elem()
+
+func isContextProvider(typ reflect.Type) bool {
+	return reflect.PointerTo(typ).Implements(contextProviderType)
+}
+
+// newContextProvider returns the Provide value with the key, it's set into the field of middleware handler.
+func newContextProvider(typ reflect.Type, key string) reflect.Value {
+	value := reflect.New(typ)
+	value.Interface().(contextProvider).setKey(key)
+	return value.Elem()
+}
+
+// missingStatusOf returns the status code responded when the context value of field is missing,
+// it comes from `missing=401` attribute, default is 500.
+func missingStatusOf(def BaseParamField) (int, error) {
+	attr, ok := def.Attrs.FirstAttrsWithKey("missing")
+	if !ok {
+		return http.StatusInternalServerError, nil
+	}
+	status, err := strconv.Atoi(attr.Val)
+	if err != nil || status < 400 || status > 599 {
+		return 0, fmt.Errorf("missing(%s) should be a status code of 4xx or 5xx", attr.Val)
+	}
+	return status, nil
+}
+
+// checkContextValues checks that every `in=context` field of the handler is provided by a middleware in the chain.
+func checkContextValues(hdlSpec *HandlerSpec, chain []scopedMiddleware, errs *SetupErrors) {
+	for _, def := range hdlSpec.InFields {
+		if !isInContextWay(def) {
+			continue
+		}
+		provided := false
+		for _, m := range chain {
+			if typ, ok := m.provides[def.PreferredName]; ok && typ.AssignableTo(def.FieldSpec.Type) {
+				provided = true
+				break
+			}
+		}
+		if !provided {
+			errs.Add(hdlSpec.newSetupError(def.FieldSpec.Name, "context value '%s'(%v) isn't provided by any middleware of the route",
+				def.PreferredName, def.FieldSpec.Type))
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2022 Yuchi Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package dij_gin
+
+import (
+	"fmt"
+	"github.com/letscool/lc-go/dij"
+	"io"
+	"path"
+	"strings"
+	"text/tabwriter"
+)
+
+// RouteParam describes a bound parameter of a route.
+type RouteParam struct {
+	Name     string `json:"name"`
+	In       InWay  `json:"in"`
+	Type     string `json:"type"`
+	Required bool   `json:"required,omitempty"`
+}
+
+// RouteInfo describes a registered route, it comes from HandlerWrapper/HandlerSpec.
+type RouteInfo struct {
+	Method      string       `json:"method"`     // upper case http method, or NOROUTE/NOMETHOD
+	Path        string       `json:"path"`       // full path in gin style, ex: /user/:id/profile
+	Controller  string       `json:"controller"` // type of controller, ex: main.TUserController
+	Handler     string       `json:"handler"`    // method name of controller, ex: GetUserById
+	Middlewares []string     `json:"middlewares,omitempty"`
+	Env         string       `json:"env,omitempty"`      // runtime environment restriction, ex: dev&test
+	Security    string       `json:"security,omitempty"` // security requirement in security tag
+	Concurrency int          `json:"concurrency,omitempty"`
+	Flags       []string     `json:"flags,omitempty"`   // feature flags which should be all on
+	Mount       string       `json:"mount,omitempty"`   // names of mounts separated by '.', ex: avatars
+	Dynamic     string       `json:"dynamic,omitempty"` // name of the dynamic controller, see DynamicRoutes
+	Params      []RouteParam `json:"params,omitempty"`
+}
+
+// RouteTable lists all routes registered by PrepareGin.
+type RouteTable struct {
+	Routes []RouteInfo `json:"routes"`
+}
+
+// GetRouteTable retrieves the route table built by PrepareGin.
+func GetRouteTable(refPtr dij.DependencyReferencePtr) *RouteTable {
+	if v, ok := refPtr.Get(RefKeyForWebRouteTable); ok {
+		return v.(*RouteTable)
+	}
+	return nil
+}
+
+// Add records a route, it's used by controllers which register routes in SetupRouter.
+func (t *RouteTable) Add(info RouteInfo) {
+	t.Routes = append(t.Routes, info)
+}
+
+// Find returns the route with method and path, the path should be in gin style, ex: /user/:id.
+func (t *RouteTable) Find(method string, path string) (RouteInfo, bool) {
+	method = strings.ToUpper(method)
+	for _, r := range t.Routes {
+		if r.Method == method && r.Path == path {
+			return r, true
+		}
+	}
+	return RouteInfo{}, false
+}
+
+// Filter returns routes which f returns true.
+func (t *RouteTable) Filter(f func(r RouteInfo) bool) []RouteInfo {
+	routes := make([]RouteInfo, 0)
+	for _, r := range t.Routes {
+		if f(r) {
+			routes = append(routes, r)
+		}
+	}
+	return routes
+}
+
+// RoutesOfController returns routes registered by the controller, controller is the type name, ex: main.TUserController.
+func (t *RouteTable) RoutesOfController(controller string) []RouteInfo {
+	return t.Filter(func(r RouteInfo) bool {
+		return r.Controller == controller
+	})
+}
+
+// Print writes the table in human-readable format.
+func (t *RouteTable) Print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "METHOD\tPATH\tHANDLER\tMIDDLEWARES\tENV\tSECURITY")
+	for _, r := range t.Routes {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s.%s\t%s\t%s\t%s\n", r.Method, r.Path, r.Controller, r.Handler,
+			strings.Join(r.Middlewares, ","), r.Env, r.Security)
+	}
+	_ = tw.Flush()
+}
+
+func (t *RouteTable) String() string {
+	var b strings.Builder
+	t.Print(&b)
+	return b.String()
+}
+
+// JoinRoutePath joins paths like gin does.
+func JoinRoutePath(basePath, relativePath string) string {
+	if relativePath == "" {
+		return basePath
+	}
+	finalPath := path.Join(basePath, relativePath)
+	if strings.HasSuffix(relativePath, "/") && !strings.HasSuffix(finalPath, "/") {
+		return finalPath + "/"
+	}
+	return finalPath
+}
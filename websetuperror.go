@@ -0,0 +1,100 @@
+// Copyright 2022 Yuchi Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package dij_gin
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// SetupError describes a misconfiguration found while building routes and handlers.
+type SetupError struct {
+	Controller reflect.Type // struct type of controller, middleware or web server
+	Method     string       // handler method name, empty if the error isn't about a handler
+	Field      string       // field name, empty if the error isn't about a field
+	Reason     string
+}
+
+func (e *SetupError) Error() string {
+	var b strings.Builder
+	if e.Controller != nil {
+		b.WriteString(e.Controller.String())
+	}
+	if len(e.Method) > 0 {
+		b.WriteString(".")
+		b.WriteString(e.Method)
+	}
+	if len(e.Field) > 0 {
+		b.WriteString(" field(")
+		b.WriteString(e.Field)
+		b.WriteString(")")
+	}
+	if b.Len() > 0 {
+		b.WriteString(": ")
+	}
+	b.WriteString(e.Reason)
+	return b.String()
+}
+
+// SetupErrors collects all setup errors, so misconfigured handlers can be reported in one run.
+type SetupErrors []*SetupError
+
+func (e *SetupErrors) Add(err *SetupError) {
+	*e = append(*e, err)
+}
+
+// Append adds all errors in err, err can be SetupErrors, *SetupError or any other error.
+func (e *SetupErrors) Append(err error) {
+	switch v := err.(type) {
+	case nil:
+	case SetupErrors:
+		*e = append(*e, v...)
+	case *SetupError:
+		e.Add(v)
+	default:
+		e.Add(&SetupError{Reason: err.Error()})
+	}
+}
+
+// Err returns nil if no error is collected.
+func (e SetupErrors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e SetupErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("%d setup errors:", len(e)))
+	for i, err := range e {
+		b.WriteString(fmt.Sprintf("\n  %d. %s", i+1, err.Error()))
+	}
+	return b.String()
+}
+
+// Unwrap returns all collected errors.
+func (e SetupErrors) Unwrap() []error {
+	errs := make([]error, 0, len(e))
+	for _, err := range e {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+func newSetupError(controller reflect.Type, field string, format string, args ...any) *SetupError {
+	for controller != nil && controller.Kind() == reflect.Pointer {
+		controller = controller.Elem()
+	}
+	return &SetupError{
+		Controller: controller,
+		Field:      field,
+		Reason:     fmt.Sprintf(format, args...),
+	}
+}
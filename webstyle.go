@@ -0,0 +1,317 @@
+// Copyright 2022 Yuchi Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package dij_gin
+
+import (
+	"encoding"
+	"fmt"
+	"github.com/letscool/lc-go/lg"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Styles of parameters in OpenAPI, they are set by `style=` attribute, and `explode` attribute:
+//
+//	Ids    []int   `http:"ids"`                              // ?ids=1&ids=2, form style and explode by default
+//	Ids    []int   `http:"ids,explode=false"`                // ?ids=1,2
+//	Ids    []int   `http:"ids,style=pipeDelimited"`          // ?ids=1|2
+//	Filter TFilter `http:"filter,in=query,style=deepObject"` // ?filter[age]=3&filter[name]=x
+//	Ids    []int   `http:"ids,in=path,style=matrix,explode"` // /users/;ids=1;ids=2
+const (
+	StyleForm           = "form"
+	StyleSimple         = "simple"
+	StyleSpaceDelimited = "spaceDelimited"
+	StylePipeDelimited  = "pipeDelimited"
+	StyleDeepObject     = "deepObject"
+	StyleMatrix         = "matrix"
+	StyleLabel          = "label"
+)
+
+// paramShape is the shape of a parameter value for decoding.
+type paramShape int
+
+const (
+	shapePrimitive paramShape = iota
+	shapeArray
+	shapeObject
+)
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// isTextValue reports whether the type is decoded from text by itself, ex: time.Time.
+func isTextValue(typ reflect.Type) bool {
+	return reflect.PointerTo(typ).Implements(textUnmarshalerType)
+}
+
+func paramShapeOf(typ reflect.Type) paramShape {
+	if isTextValue(typ) {
+		return shapePrimitive
+	}
+	switch typ.Kind() {
+	case reflect.Array, reflect.Slice:
+		return shapeArray
+	case reflect.Struct:
+		return shapeObject
+	}
+	return shapePrimitive
+}
+
+// paramStyle is the serialization of a parameter.
+type paramStyle struct {
+	style   string
+	explode bool
+}
+
+// defaultParamStyle returns the default style of the way, form for query and cookie, simple for path and header.
+func defaultParamStyle(in InWay) paramStyle {
+	switch in {
+	case InPathWay, InHeaderWay:
+		return paramStyle{StyleSimple, false}
+	case InCookieWay:
+		return paramStyle{StyleForm, false}
+	}
+	return paramStyle{StyleForm, true}
+}
+
+// paramStyleOf returns the style of field by `style` and `explode` attributes, explicit is true if any of them is set.
+func paramStyleOf(def BaseParamField, in InWay) (style paramStyle, explicit bool, err error) {
+	style = defaultParamStyle(in)
+	if attr, ok := def.Attrs.FirstAttrsWithKey("style"); ok {
+		style.style, explicit = attr.Val, true
+		style.explode = style.style == StyleForm || style.style == StyleDeepObject
+	}
+	if attr, ok := def.Attrs.FirstAttrsWithKey("explode"); ok {
+		if style.explode, err = strconv.ParseBool(attr.Val); err != nil {
+			return style, true, fmt.Errorf("explode(%s) should be true or false", attr.Val)
+		}
+		explicit = true
+	} else if def.Attrs.ContainsAttrWithValOnly("explode") {
+		style.explode, explicit = true, true
+	}
+	if !explicit {
+		return style, false, nil
+	}
+	shape := paramShapeOf(def.FieldSpec.Type)
+	var ways []InWay
+	switch style.style {
+	case StyleForm:
+		ways = []InWay{InQueryWay, InCookieWay, InBodyWay}
+	case StyleSimple:
+		ways = []InWay{InPathWay, InHeaderWay}
+	case StyleMatrix, StyleLabel:
+		ways = []InWay{InPathWay}
+	case StyleSpaceDelimited, StylePipeDelimited:
+		ways = []InWay{InQueryWay}
+		if shape != shapeArray {
+			return style, true, fmt.Errorf("style(%s) only supports array", style.style)
+		}
+	case StyleDeepObject:
+		ways = []InWay{InQueryWay}
+		if shape != shapeObject {
+			return style, true, fmt.Errorf("style(%s) only supports object", style.style)
+		}
+	default:
+		return style, true, fmt.Errorf("unsupported style: %s", style.style)
+	}
+	if !lg.Contains(ways, in) {
+		return style, true, fmt.Errorf("style(%s) doesn't support the value in %s", style.style, in)
+	}
+	return style, true, nil
+}
+
+// rawParam is the decoded text of a parameter, items for primitive and array, props for object.
+type rawParam struct {
+	items []string
+	props map[string]string
+}
+
+// decodeValues decodes the parameter from query or form values, array returns values of a key and dict returns
+// values of keys like key[prop].
+func (s paramStyle) decodeValues(key string, shape paramShape, propNames []string,
+	array func(key string) ([]string, bool), dict func(key string) (map[string]string, bool)) (raw rawParam, exists bool) {
+	if shape == shapeObject {
+		switch {
+		case s.style == StyleDeepObject:
+			raw.props, exists = dict(key)
+		case s.explode:
+			raw.props = map[string]string{}
+			for _, name := range propNames {
+				if values, ok := array(name); ok && len(values) > 0 {
+					raw.props[name] = values[0]
+				}
+			}
+			exists = len(raw.props) > 0
+		default:
+			var values []string
+			if values, exists = array(key); exists && len(values) > 0 {
+				raw.props = pairsOf(strings.Split(values[0], ","))
+			}
+		}
+		return
+	}
+	values, exists := array(key)
+	if !exists || len(values) == 0 {
+		return raw, false
+	}
+	if shape == shapePrimitive || s.explode {
+		raw.items = values
+		return raw, true
+	}
+	separator := ","
+	switch s.style {
+	case StyleSpaceDelimited:
+		separator = " "
+	case StylePipeDelimited:
+		separator = "|"
+	}
+	raw.items = splitItems(values[0], separator)
+	return raw, true
+}
+
+// decodeText decodes the parameter from the text of path, header or cookie.
+func (s paramStyle) decodeText(key string, text string, shape paramShape) (raw rawParam) {
+	separator := ","
+	switch s.style {
+	case StyleLabel:
+		text = strings.TrimPrefix(text, ".")
+		if s.explode {
+			separator = "."
+		}
+	case StyleMatrix:
+		text = strings.TrimPrefix(text, ";")
+		if s.explode && shape != shapePrimitive {
+			separator = ";"
+			if shape == shapeArray {
+				// ;ids=1;ids=2
+				for _, item := range strings.Split(text, separator) {
+					raw.items = append(raw.items, strings.TrimPrefix(item, key+"="))
+				}
+				return
+			}
+			break
+		}
+		text = strings.TrimPrefix(text, key+"=")
+	}
+	switch shape {
+	case shapePrimitive:
+		raw.items = []string{text}
+	case shapeArray:
+		raw.items = splitItems(text, separator)
+	case shapeObject:
+		if s.explode {
+			raw.props = map[string]string{}
+			for _, pair := range strings.Split(text, separator) {
+				if k, v, ok := strings.Cut(pair, "="); ok {
+					raw.props[k] = v
+				}
+			}
+		} else {
+			raw.props = pairsOf(strings.Split(text, separator))
+		}
+	}
+	return
+}
+
+func splitItems(text string, separator string) []string {
+	if len(text) == 0 {
+		return []string{}
+	}
+	return strings.Split(text, separator)
+}
+
+// pairsOf converts [k1, v1, k2, v2] into a map.
+func pairsOf(items []string) map[string]string {
+	props := map[string]string{}
+	for i := 0; i+1 < len(items); i += 2 {
+		props[items[i]] = items[i+1]
+	}
+	return props
+}
+
+// propBinding is a property of an object parameter.
+type propBinding struct {
+	index   int
+	name    string // json name, or field name
+	convert valueConverter
+}
+
+// isObjectParam reports whether the struct field is an object parameter instead of request body,
+// the field should have `style` attribute, or `in` attribute with a way other than body.
+func isObjectParam(def BaseParamField) bool {
+	if _, ok := def.Attrs.FirstAttrsWithKey("style"); ok {
+		return true
+	}
+	attr, ok := def.Attrs.FirstAttrsWithKey("in")
+	return ok && attr.Val != InBodyWay && normalizeInWay(attr.Val) != InContextWay
+}
+
+func propBindingsOf(typ reflect.Type) []propBinding {
+	var props []propBinding
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := field.Name
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			if tag = strings.Split(tag, ",")[0]; tag == "-" {
+				continue
+			} else if len(tag) > 0 {
+				name = tag
+			}
+		}
+		props = append(props, propBinding{i, name, newValueConverter(field.Type)})
+	}
+	return props
+}
+
+// assign converts the raw param into dst, dst is addressable and settable.
+// It returns the text which fails to convert with the error.
+func (fb *fieldBinding) assign(raw rawParam, dst reflect.Value) (string, error) {
+	switch fb.shape {
+	case shapeArray:
+		value := dst
+		if dst.Kind() == reflect.Slice {
+			value = reflect.MakeSlice(dst.Type(), len(raw.items), len(raw.items))
+		} else if len(raw.items) > dst.Len() {
+			return strings.Join(raw.items, ","), fmt.Errorf("too many items(%d) for %v", len(raw.items), dst.Type())
+		}
+		for i, item := range raw.items {
+			if err := fb.convert(item, value.Index(i)); err != nil {
+				return item, fmt.Errorf("item %d: %w", i, err)
+			}
+		}
+		dst.Set(value)
+	case shapeObject:
+		for _, prop := range fb.props {
+			if text, ok := raw.props[prop.name]; ok {
+				if err := prop.convert(text, dst.Field(prop.index)); err != nil {
+					return text, fmt.Errorf("property %s: %w", prop.name, err)
+				}
+			}
+		}
+	default:
+		if len(raw.items) > 0 {
+			return raw.items[0], fb.convert(raw.items[0], dst)
+		}
+	}
+	return "", nil
+}
+
+// decode reads the styled parameter from request.
+func (fb *fieldBinding) decode(ctx WebContext) (raw rawParam, exists bool) {
+	switch fb.in {
+	case InQueryWay:
+		return fb.style.decodeValues(fb.key, fb.shape, fb.propNames, ctx.GetQueryArray, ctx.GetQueryMap)
+	case InBodyWay:
+		return fb.style.decodeValues(fb.key, fb.shape, fb.propNames, ctx.GetPostFormArray, ctx.GetPostFormMap)
+	}
+	text, exists := ctx.getRequestText(fb.key, fb.in)
+	if !exists {
+		return raw, false
+	}
+	return fb.style.decodeText(fb.key, text, fb.shape), true
+}
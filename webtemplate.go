@@ -0,0 +1,290 @@
+// Copyright 2022 Yuchi Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package dij_gin
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/letscool/lc-go/dij"
+	"html/template"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const RefKeyForWebTemplates = "_.webserver.templates"
+
+// TemplateConfig presents the settings of html templates, a result field with `template=name` attribute is rendered by them.
+//
+// Every file with Ext is a template named by its path without Ext, ex: "user/profile" for user/profile.html.
+// Files in LayoutDir and PartialDir are shared by all pages, so a page can use partials by {{template "partials/nav" .}},
+// and override the blocks of layout by {{define "content"}}...{{end}}.
+type TemplateConfig struct {
+	Dir        string           // directory on disk, FS is used if it's empty.
+	FS         fs.FS            // ex: embed.FS
+	Root       string           // sub directory in Dir or FS, ex: "templates"
+	Ext        string           // extension of template files, default is ".html"
+	LayoutDir  string           // default is "layouts"
+	PartialDir string           // default is "partials"
+	Layout     string           // default layout of pages, the name in LayoutDir, ex: "base". Empty means no layout.
+	Funcs      template.FuncMap // functions for all templates
+	Reload     *bool            // reload templates for every rendering, nil means reloading only in dev runtime environment.
+}
+
+func (t *TemplateConfig) ApplyDefaultValues() {
+	if t.Ext == "" {
+		t.Ext = ".html"
+	}
+	if t.LayoutDir == "" {
+		t.LayoutDir = "layouts"
+	}
+	if t.PartialDir == "" {
+		t.PartialDir = "partials"
+	}
+}
+
+// IsEnabled returns true if Dir or FS is set.
+func (t *TemplateConfig) IsEnabled() bool {
+	return len(t.Dir) > 0 || t.FS != nil
+}
+
+func (t *TemplateConfig) SetDir(dir string) *TemplateConfig {
+	t.Dir = dir
+	return t
+}
+
+func (t *TemplateConfig) SetFS(fsys fs.FS, root string) *TemplateConfig {
+	t.FS = fsys
+	t.Root = root
+	return t
+}
+
+func (t *TemplateConfig) SetLayout(layout string) *TemplateConfig {
+	t.Layout = layout
+	return t
+}
+
+func (t *TemplateConfig) SetFunc(name string, fn any) *TemplateConfig {
+	if t.Funcs == nil {
+		t.Funcs = template.FuncMap{}
+	}
+	t.Funcs[name] = fn
+	return t
+}
+
+func (t *TemplateConfig) SetFuncs(funcs template.FuncMap) *TemplateConfig {
+	for name, fn := range funcs {
+		t.SetFunc(name, fn)
+	}
+	return t
+}
+
+func (t *TemplateConfig) SetReload(reload bool) *TemplateConfig {
+	t.Reload = &reload
+	return t
+}
+
+// isReloading returns true if templates should be reloaded in the runtime environment.
+func (t *TemplateConfig) isReloading(env RuntimeEnv) bool {
+	if t.Reload != nil {
+		return *t.Reload
+	}
+	return env == RtDev
+}
+
+func (t *TemplateConfig) open() (fs.FS, error) {
+	var fsys fs.FS
+	if len(t.Dir) > 0 {
+		fsys = os.DirFS(t.Dir)
+	} else if t.FS != nil {
+		fsys = t.FS
+	} else {
+		return nil, errors.New("template needs a dir or a FS")
+	}
+	if root := strings.Trim(t.Root, "/"); len(root) > 0 && root != "." {
+		return fs.Sub(fsys, root)
+	}
+	return fsys, nil
+}
+
+// TemplateRenderer renders the pages of TemplateConfig, it is created by PrepareGin if templates are enabled.
+type TemplateRenderer struct {
+	config TemplateConfig
+	reload bool
+	mu     sync.RWMutex
+	pages  map[string]*template.Template // page name -> page with layouts and partials
+}
+
+// NewTemplateRenderer loads all templates, the templates are loaded again before every rendering if reload is true.
+func NewTemplateRenderer(config TemplateConfig, reload bool) (*TemplateRenderer, error) {
+	config.ApplyDefaultValues()
+	r := &TemplateRenderer{config: config, reload: reload}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetTemplateRenderer retrieves the renderer from DependentRefs.
+func GetTemplateRenderer(refPtr dij.DependencyReferencePtr) (*TemplateRenderer, bool) {
+	if v, ok := refPtr.Get(RefKeyForWebTemplates); ok {
+		r, ok := v.(*TemplateRenderer)
+		return r, ok
+	}
+	return nil, false
+}
+
+func (r *TemplateRenderer) isShared(name string) bool {
+	return strings.HasPrefix(name, r.config.LayoutDir+"/") || strings.HasPrefix(name, r.config.PartialDir+"/")
+}
+
+func (r *TemplateRenderer) load() error {
+	fsys, err := r.config.open()
+	if err != nil {
+		return err
+	}
+	files := map[string]string{} // name -> content
+	err = fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(p, r.config.Ext) {
+			return nil
+		}
+		data, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		files[strings.TrimSuffix(p, r.config.Ext)] = string(data)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("load templates error: %w", err)
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	shared := template.New("").Funcs(r.config.Funcs)
+	for _, name := range names {
+		if r.isShared(name) {
+			if _, err := shared.New(name).Parse(files[name]); err != nil {
+				return fmt.Errorf("parse template(%s) error: %w", name, err)
+			}
+		}
+	}
+	pages := map[string]*template.Template{}
+	for _, name := range names {
+		if r.isShared(name) {
+			continue
+		}
+		page, err := shared.Clone()
+		if err == nil {
+			_, err = page.New(name).Parse(files[name])
+		}
+		if err != nil {
+			return fmt.Errorf("parse template(%s) error: %w", name, err)
+		}
+		pages[name] = page
+	}
+	if len(r.config.Layout) > 0 && shared.Lookup(path.Join(r.config.LayoutDir, r.config.Layout)) == nil {
+		return fmt.Errorf("default layout(%s) doesn't exist", r.config.Layout)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pages = pages
+	return nil
+}
+
+// Names returns sorted names of all pages.
+func (r *TemplateRenderer) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.pages))
+	for name := range r.pages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookup returns the page and the name of template to execute, layout "-" means no layout and empty means the default one.
+func (r *TemplateRenderer) lookup(name, layout string) (*template.Template, string, error) {
+	r.mu.RLock()
+	page, ok := r.pages[name]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, "", fmt.Errorf("template(%s) doesn't exist", name)
+	}
+	if layout == "" {
+		layout = r.config.Layout
+	}
+	if layout == "" || layout == "-" {
+		return page, name, nil
+	}
+	layoutName := path.Join(r.config.LayoutDir, layout)
+	if page.Lookup(layoutName) == nil {
+		return nil, "", fmt.Errorf("layout(%s) doesn't exist", layout)
+	}
+	return page, layoutName, nil
+}
+
+// Render executes the page with the layout, layout "-" means no layout and empty means the default one.
+func (r *TemplateRenderer) Render(w io.Writer, name, layout string, data any) error {
+	if r.reload {
+		if err := r.load(); err != nil {
+			return err
+		}
+	}
+	page, execName, err := r.lookup(name, layout)
+	if err != nil {
+		return err
+	}
+	// render into buffer, so a failed rendering doesn't write a partial page.
+	var buf bytes.Buffer
+	if err := page.ExecuteTemplate(&buf, execName, data); err != nil {
+		return err
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// templateOfField returns the template and layout from the attributes of result field.
+func templateOfField(field BaseParamField) (name, layout string, ok bool) {
+	attr, ok := field.Attrs.FirstAttrsWithKey("template")
+	if !ok {
+		return "", "", false
+	}
+	if attr, exists := field.Attrs.FirstAttrsWithKey("layout"); exists {
+		layout = attr.Val
+	}
+	return strings.Trim(attr.Val, "/"), layout, true
+}
+
+// checkTemplates verifies the templates and layouts of result fields exist.
+func checkTemplates(hdlSpec *HandlerSpec, refPtr dij.DependencyReferencePtr, errs *SetupErrors) {
+	for _, field := range hdlSpec.OutFields {
+		name, layout, ok := templateOfField(field)
+		if !ok {
+			continue
+		}
+		renderer, exists := GetTemplateRenderer(refPtr)
+		if !exists {
+			errs.Add(hdlSpec.newSetupError(field.FieldSpec.Name, "template(%s) needs WebConfig.Template with a dir or a FS", name))
+			continue
+		}
+		if _, _, err := renderer.lookup(name, layout); err != nil {
+			errs.Add(hdlSpec.newSetupError(field.FieldSpec.Name, "%v", err))
+		}
+	}
+}
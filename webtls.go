@@ -0,0 +1,186 @@
+// Copyright 2022 Yuchi Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package dij_gin
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+// TlsConfig presents the https settings of web server.
+// The certificate comes from Config, or CertFile/KeyFile. If none of them is set and runtime environment is dev or test,
+// a self-signed certificate will be generated.
+type TlsConfig struct {
+	Enabled      bool               // Default is false
+	CertFile     string             // PEM encoded certificate file
+	KeyFile      string             // PEM encoded private key file
+	Config       *tls.Config        // in-memory config, it is cloned and other settings are applied on the clone.
+	MinVersion   uint16             // Default is tls.VersionTLS12
+	ClientCAFile string             // PEM encoded CA certificates for verifying client certificates, aka. mTLS.
+	ClientCAs    *x509.CertPool     // CA pool for verifying client certificates, aka. mTLS.
+	ClientAuth   tls.ClientAuthType // Default is tls.RequireAndVerifyClientCert if any client CA is set.
+}
+
+func (t *TlsConfig) ApplyDefaultValues() {
+	if t.MinVersion == 0 {
+		t.MinVersion = tls.VersionTLS12
+	}
+}
+
+func (t *TlsConfig) Enable() *TlsConfig {
+	t.Enabled = true
+	return t
+}
+
+func (t *TlsConfig) SetEnabled(en bool) *TlsConfig {
+	t.Enabled = en
+	return t
+}
+
+func (t *TlsConfig) SetCertFiles(certFile, keyFile string) *TlsConfig {
+	t.CertFile = certFile
+	t.KeyFile = keyFile
+	return t
+}
+
+func (t *TlsConfig) SetConfig(config *tls.Config) *TlsConfig {
+	t.Config = config
+	return t
+}
+
+func (t *TlsConfig) SetMinVersion(version uint16) *TlsConfig {
+	t.MinVersion = version
+	return t
+}
+
+func (t *TlsConfig) SetClientCAFile(file string) *TlsConfig {
+	t.ClientCAFile = file
+	return t
+}
+
+func (t *TlsConfig) SetClientCAs(pool *x509.CertPool) *TlsConfig {
+	t.ClientCAs = pool
+	return t
+}
+
+func (t *TlsConfig) SetClientAuth(auth tls.ClientAuthType) *TlsConfig {
+	t.ClientAuth = auth
+	return t
+}
+
+// BuildTlsConfig builds tls config for http server, nil will be returned if tls isn't enabled.
+func (c *WebConfig) BuildTlsConfig() (*tls.Config, error) {
+	t := &c.Tls
+	if !t.Enabled {
+		return nil, nil
+	}
+	var config *tls.Config
+	if t.Config != nil {
+		config = t.Config.Clone()
+	} else {
+		config = &tls.Config{}
+	}
+	if t.MinVersion > config.MinVersion {
+		config.MinVersion = t.MinVersion
+	}
+
+	if len(t.CertFile) > 0 || len(t.KeyFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load tls certificate error: %w", err)
+		}
+		config.Certificates = append(config.Certificates, cert)
+	}
+	if len(config.Certificates) == 0 && config.GetCertificate == nil {
+		if env := c.BaseEnv(); env != RtDev && env != RtTest {
+			return nil, fmt.Errorf("tls certificate is required in '%s' runtime environment", c.RtEnv)
+		}
+		cert, err := GenerateSelfSignedCertificate(c.Address, "localhost", "127.0.0.1", "::1")
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = append(config.Certificates, cert)
+	}
+
+	clientCAs := t.ClientCAs
+	if len(t.ClientCAFile) > 0 {
+		pem, err := os.ReadFile(t.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client CA error: %w", err)
+		}
+		if clientCAs == nil {
+			clientCAs = x509.NewCertPool()
+		} else {
+			clientCAs = clientCAs.Clone()
+		}
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in client CA file(%s)", t.ClientCAFile)
+		}
+	}
+	if clientCAs != nil {
+		config.ClientCAs = clientCAs
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if t.ClientAuth != tls.NoClientCert {
+		config.ClientAuth = t.ClientAuth
+	}
+	return config, nil
+}
+
+// GenerateSelfSignedCertificate generates a certificate for development or testing only, hosts can be domain names or ip addresses.
+// The certificate can be used for both server and client authentication, and also acts as its own CA.
+func GenerateSelfSignedCertificate(hosts ...string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{Organization: []string{"dij-gin development"}, CommonName: "dij-gin"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, host := range hosts {
+		if len(host) == 0 {
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
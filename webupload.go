@@ -0,0 +1,247 @@
+// Copyright 2022 Yuchi Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package dij_gin
+
+import (
+	"errors"
+	"fmt"
+	"github.com/letscool/dij-gin/spec"
+	"github.com/letscool/lc-go/lg"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// FileStream is an uploaded file opened for reading, it's closed after the handler returns.
+// Fields of *multipart.FileHeader and []*multipart.FileHeader are also supported if the handler opens files itself:
+//
+//	func (c *TFileController) PostAvatar(ctx struct {
+//	  WebContext `http:"avatar,multipart"`
+//	  Avatar     FileStream              `http:"avatar,required,maxsize=1MB,accept=image/png&image/jpeg"`
+//	  Photos     []*multipart.FileHeader `http:"photos,maxcount=3,accept=image/*"`
+//	}) {
+//	  io.Copy(dst, ctx.Avatar)
+//	}
+type FileStream struct {
+	multipart.File
+	*multipart.FileHeader
+}
+
+var (
+	fileHeaderPtrType = reflect.TypeOf((*multipart.FileHeader)(nil))
+	fileStreamType    = reflect.TypeOf(FileStream{})
+)
+
+func isFileType(typ reflect.Type) bool {
+	if typ.Kind() == reflect.Slice {
+		typ = typ.Elem()
+	}
+	return typ == fileHeaderPtrType || typ == fileStreamType
+}
+
+// fileLimits restricts the uploaded files of a field by `maxsize=`, `maxcount=` and `accept=` attributes.
+type fileLimits struct {
+	maxSize  int64    // bytes of a file, 0 means unlimited
+	maxCount int      // files of a slice field, 0 means unlimited
+	accept   []string // MIME types, ex: image/png, image/*
+}
+
+func fileLimitsOf(def BaseParamField) (limits fileLimits, err error) {
+	if attr, ok := def.Attrs.FirstAttrsWithKey("maxsize"); ok {
+		if limits.maxSize, err = parseByteSize(attr.Val); err != nil {
+			return limits, fmt.Errorf("maxsize(%s) should be a positive size, ex: 512KB, 10MB", attr.Val)
+		}
+	}
+	if attr, ok := def.Attrs.FirstAttrsWithKey("maxcount"); ok {
+		if def.FieldSpec.Type.Kind() != reflect.Slice {
+			return limits, fmt.Errorf("maxcount is only supported by a slice of files")
+		}
+		if limits.maxCount, err = strconv.Atoi(attr.Val); err != nil || limits.maxCount <= 0 {
+			return limits, fmt.Errorf("maxcount(%s) should be a positive integer", attr.Val)
+		}
+	}
+	if attr, ok := def.Attrs.FirstAttrsWithKey("accept"); ok {
+		limits.accept = lg.Filter(strings.Split(attr.Val, "&"), func(t string) bool { return len(t) > 0 })
+		for _, t := range limits.accept {
+			if _, _, err = mime.ParseMediaType(t); err != nil {
+				return limits, fmt.Errorf("accept(%s) has an incorrect MIME type: %s", attr.Val, t)
+			}
+		}
+	}
+	return limits, nil
+}
+
+// parseByteSize parses the size with an optional unit of B, KB, MB or GB, units are 1024 based.
+func parseByteSize(text string) (int64, error) {
+	units := []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+	unit := int64(1)
+	text = strings.ToUpper(strings.TrimSpace(text))
+	for _, u := range units {
+		if strings.HasSuffix(text, u.suffix) {
+			text, unit = strings.TrimSpace(strings.TrimSuffix(text, u.suffix)), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(text, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, errors.New("incorrect size")
+	}
+	return n * unit, nil
+}
+
+// multipartFormAllowance is the bytes of form fields and part headers in a multipart body besides files.
+const multipartFormAllowance = 1 << 20
+
+// maxBytes returns the max bytes of files of a field with the type, it's 0 if the files are unlimited.
+func (l fileLimits) maxBytes(typ reflect.Type) int64 {
+	if typ.Kind() != reflect.Slice {
+		return l.maxSize
+	}
+	return l.maxSize * int64(l.maxCount)
+}
+
+// check returns an error if the files violate the limits.
+func (l fileLimits) check(files []*multipart.FileHeader) error {
+	if l.maxCount > 0 && len(files) > l.maxCount {
+		return fmt.Errorf("too many files(%d), the limit is %d", len(files), l.maxCount)
+	}
+	for _, file := range files {
+		if l.maxSize > 0 && file.Size > l.maxSize {
+			return fmt.Errorf("file '%s' is too large(%d bytes), the limit is %d bytes", file.Filename, file.Size, l.maxSize)
+		}
+		if len(l.accept) > 0 && !l.accepts(file.Header.Get("Content-Type")) {
+			return fmt.Errorf("file '%s' has an unacceptable type(%s)", file.Filename, file.Header.Get("Content-Type"))
+		}
+	}
+	return nil
+}
+
+// accepts checks the Content-Type which the client declares in the part header, the content isn't sniffed,
+// so the handler should verify the content if the type matters, ex: http.DetectContentType.
+func (l fileLimits) accepts(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range l.accept {
+		if t == mediaType || strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(t, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// formFiles returns the uploaded files of key, a request which isn't multipart has no files.
+func formFiles(ctx WebContext, key string) ([]*multipart.FileHeader, error) {
+	form, err := ctx.MultipartForm()
+	if errors.Is(err, http.ErrNotMultipart) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return form.File[key], nil
+}
+
+// assignFiles sets the files into dst, FileStream values are opened.
+func assignFiles(files []*multipart.FileHeader, dst reflect.Value) error {
+	typ := dst.Type()
+	if typ.Kind() != reflect.Slice {
+		return assignFile(files[0], dst)
+	}
+	value := reflect.MakeSlice(typ, len(files), len(files))
+	dst.Set(value) // opened files are closed even if a later one fails to open
+	for i, file := range files {
+		if err := assignFile(file, value.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func assignFile(file *multipart.FileHeader, dst reflect.Value) error {
+	if dst.Type() == fileHeaderPtrType {
+		dst.Set(reflect.ValueOf(file))
+		return nil
+	}
+	f, err := file.Open()
+	if err != nil {
+		return err
+	}
+	dst.Set(reflect.ValueOf(FileStream{File: f, FileHeader: file}))
+	return nil
+}
+
+// closeFiles closes opened FileStream values of the field.
+func closeFiles(field reflect.Value) {
+	var streams []FileStream
+	switch v := field.Interface().(type) {
+	case FileStream:
+		streams = append(streams, v)
+	case []FileStream:
+		streams = v
+	}
+	for _, stream := range streams {
+		if stream.File != nil {
+			_ = stream.File.Close()
+		}
+	}
+}
+
+// multipartSchemaOf returns the object schema of multipart request body, its properties are the fields in body and
+// files are binary strings. The encoding has the accepted MIME types of files.
+func multipartSchemaOf(w *HandlerWrapper, pathParamNames []string) (schema spec.SchemaR, encoding map[string]spec.Encoding) {
+	schema = spec.SchemaR{Schema: &spec.Schema{Type: "object", Properties: map[string]spec.SchemaR{}}}
+	for _, def := range w.Spec.InFields {
+		typ := def.FieldSpec.Type
+		switch {
+		case def.FieldSpec.Anonymous && typ == WebCtxType, isInContextWay(def):
+			continue
+		case isFileType(typ):
+			prop := spec.SchemaR{Schema: &spec.Schema{Type: "string", Format: "binary"}}
+			limits, _ := fileLimitsOf(def)
+			if typ.Kind() == reflect.Slice {
+				item := prop
+				prop = spec.SchemaR{Schema: &spec.Schema{Type: "array", Items: &item}}
+				if limits.maxCount > 0 {
+					prop.MaxItems = limits.maxCount
+				}
+			}
+			schema.Properties[def.PreferredName] = prop
+			if len(limits.accept) > 0 {
+				if encoding == nil {
+					encoding = map[string]spec.Encoding{}
+				}
+				encoding[def.PreferredName] = spec.Encoding{ContentType: strings.Join(limits.accept, ", ")}
+			}
+		case w.InWayOf(def, pathParamNames) != InBodyWay:
+			continue
+		default:
+			prop := spec.SchemaR{}
+			prop.ApplyType(typ)
+			if prop.Type == "object" && len(prop.Properties) > 0 {
+				// a struct is bound from the fields of form
+				for name, p := range prop.Properties {
+					schema.Properties[name] = p
+				}
+				schema.Required = append(schema.Required, prop.Required...)
+				continue
+			}
+			if value, exists, _ := defaultValueOf(def); exists {
+				prop.Default = value.Interface()
+			}
+			schema.Properties[def.PreferredName] = prop
+		}
+		if def.Attrs.ContainsAttrWithValOnly("required") {
+			schema.Required = append(schema.Required, def.PreferredName)
+		}
+	}
+	return
+}